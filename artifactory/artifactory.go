@@ -1,8 +1,13 @@
 package artifactory
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/jfrog/jfrog-client-go/artifactory"
 	artAuth "github.com/jfrog/jfrog-client-go/artifactory/auth"
@@ -14,7 +19,35 @@ import (
 )
 
 type ArtifactoryManger struct {
-	manager artifactory.ArtifactoryServicesManager
+	manager   artifactory.ArtifactoryServicesManager
+	anonymous bool
+}
+
+// Properties set on uploaded kernel files
+const (
+	PropKernel         = "kernel.name"
+	PropDistro         = "kernel.distro"
+	PropDistroVersion  = "kernel.distroVersion"
+	PropUpstreamURL    = "kernel.upstreamUrl"
+	PropUpstreamSha256 = "kernel.upstreamSha256"
+	PropSyncTime       = "kernel.syncTimestamp"
+	PropToolVersion    = "kernel.toolVersion"
+	// PropAdvisories is multi-valued, every value is an advisory shipping the
+	// kernel package
+	PropAdvisories = "kernel.advisories"
+)
+
+// KernelFile is a downloaded kernel source file with its upstream metadata
+type KernelFile struct {
+	LocalPath     string
+	Distro        string
+	DistroVersion string
+	Kernel        string
+	UpstreamURL   string
+	// UpstreamSha256 is checksum published by upstream, empty when upstream
+	// doesn't publish it
+	UpstreamSha256 string
+	Advisories     []string
 }
 
 type ArtifactoryKernelCache interface {
@@ -27,10 +60,18 @@ type artifactoryKernelCache struct {
 	kernels map[string]string
 }
 
+func NewKernelCache() *artifactoryKernelCache { return &artifactoryKernelCache{} }
+
 func (a *artifactoryKernelCache) Empty() bool { return a.kernels == nil }
 func (a *artifactoryKernelCache) Set(kernels map[string]string) {
 	a.kernels = kernels
 }
+func (a *artifactoryKernelCache) Add(distro, version, fileName, chksum string) {
+	if a.kernels == nil {
+		a.kernels = make(map[string]string)
+	}
+	a.kernels[fmt.Sprintf("%s_%s_%s", distro, version, fileName)] = chksum
+}
 func (a *artifactoryKernelCache) InCache(distro, version, fileName string) bool {
 	keyName := fmt.Sprintf("%s_%s_%s", distro, version, fileName)
 	if _, ok := a.kernels[keyName]; ok {
@@ -52,7 +93,10 @@ func NewArtifactoryManger(logger logger.LoggerWithOutput, artifactoryBaseURL, ar
 
 	rtDetails := artAuth.NewArtifactoryDetails()
 	rtDetails.SetUrl(artifactoryBaseURL)
-	rtDetails.SetApiKey(artifactoryToken)
+	// without token read only repositories are accessed anonymously
+	if artifactoryToken != "" {
+		rtDetails.SetApiKey(artifactoryToken)
+	}
 	serviceConfig, err := artConfig.NewConfigBuilder().
 		SetServiceDetails(rtDetails).
 		Build()
@@ -63,25 +107,161 @@ func NewArtifactoryManger(logger logger.LoggerWithOutput, artifactoryBaseURL, ar
 	if err != nil {
 		return mgr, fmt.Errorf("unable create manger: %v", err)
 	}
-	mgr = ArtifactoryManger{rtManager}
+	mgr = ArtifactoryManger{manager: rtManager, anonymous: artifactoryToken == ""}
 	return mgr, nil
 }
 
-func (a *ArtifactoryManger) UploadFiles(repoPath, localPath string) (int, int, error) {
-	params := artServices.NewUploadParams()
-	params.Pattern = localPath
-	params.Target = repoPath
-	/*
-		Flat
-		If true, files are uploaded to the exact target path specified and their hierarchy in the source file system is ignored.
-		If false, files are uploaded to the target path while maintaining their file system hierarchy.
-	*/
-	params.Flat = false
-	params.Recursive = true
-	params.IncludeDirs = true
-	params.Ant = true
-	params.ChecksumsCalcEnabled = true
-	return a.manager.UploadFiles(params)
+// UploadKernelFiles uploads files to [repo]/[distro]/[version]/ and sets
+// kernel metadata as artifactory properties.
+func (a *ArtifactoryManger) UploadKernelFiles(repo string, files []KernelFile, toolVersion string) (int, int, error) {
+	syncTime := time.Now().UTC().Format(time.RFC3339)
+	var uploadParams []artServices.UploadParams
+	for _, f := range files {
+		props := artUtils.NewProperties()
+		props.AddProperty(PropKernel, f.Kernel)
+		props.AddProperty(PropDistro, f.Distro)
+		props.AddProperty(PropDistroVersion, f.DistroVersion)
+		props.AddProperty(PropUpstreamURL, stripQuery(f.UpstreamURL))
+		if f.UpstreamSha256 != "" {
+			props.AddProperty(PropUpstreamSha256, f.UpstreamSha256)
+		}
+		props.AddProperty(PropSyncTime, syncTime)
+		props.AddProperty(PropToolVersion, toolVersion)
+		for _, advisory := range f.Advisories {
+			props.AddProperty(PropAdvisories, advisory)
+		}
+		params := artServices.NewUploadParams()
+		params.Pattern = f.LocalPath
+		params.Target = path.Join(repo, f.Distro, f.DistroVersion) + "/"
+		params.Flat = true
+		params.ChecksumsCalcEnabled = true
+		params.TargetProps = props
+		uploadParams = append(uploadParams, params)
+	}
+	if len(uploadParams) == 0 {
+		return 0, 0, nil
+	}
+	return a.manager.UploadFiles(uploadParams...)
+}
+
+// upstream urls can contain temporary credentials in query (e.g. RedHat CDN)
+func stripQuery(fileURL string) string {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return fileURL
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// ListKernelFiles lists kernel files of distribution version together with
+// their properties using the search API. Returned map is indexed by file name.
+func (a *ArtifactoryManger) ListKernelFiles(repo, distro, version string) (map[string]artUtils.ResultItem, error) {
+	params := artServices.NewSearchParams()
+	params.Recursive = false
+	params.Pattern = path.Join(repo, distro, version) + "/*"
+	reader, err := a.manager.SearchFiles(params)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	files := make(map[string]artUtils.ResultItem)
+	for currentResult := new(artUtils.ResultItem); reader.NextRecord(currentResult) == nil; currentResult = new(artUtils.ResultItem) {
+		files[currentResult.Name] = *currentResult
+	}
+	if err := reader.GetError(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// CachedFile is a kernel source file available in cache
+type CachedFile struct {
+	URL    string
+	Sha256 string
+	Size   int64
+	// Advisories shipping the file recorded at sync time
+	Advisories []string
+}
+
+// RepoCache exposes artifactory repository as kernel sources cache
+type RepoCache struct {
+	manager *ArtifactoryManger
+	client  *http.Client
+	baseURL string
+	repo    string
+}
+
+func (a *ArtifactoryManger) RepoCache(client *http.Client, artifactoryBaseURL, repo string) *RepoCache {
+	return &RepoCache{manager: a, client: client, baseURL: strings.TrimSuffix(artifactoryBaseURL, "/"), repo: strings.Trim(repo, "/")}
+}
+
+func (c *RepoCache) ListFiles(distro, version string) (map[string]CachedFile, error) {
+	items, err := c.manager.ListKernelFiles(c.repo, distro, version)
+	if err != nil {
+		if !c.manager.anonymous {
+			return nil, err
+		}
+		// search API can be disabled for anonymous users, storage API is
+		// available for every readable repository
+		return c.storageList(distro, version)
+	}
+	files := make(map[string]CachedFile)
+	for name, item := range items {
+		cachedFile := CachedFile{
+			URL:    c.baseURL + "/" + path.Join(item.Repo, item.Path, item.Name),
+			Sha256: item.Sha256,
+			Size:   item.Size,
+		}
+		for _, prop := range item.Properties {
+			if prop.Key == PropAdvisories {
+				cachedFile.Advisories = append(cachedFile.Advisories, prop.Value)
+			}
+		}
+		files[name] = cachedFile
+	}
+	return files, nil
+}
+
+type storageFileList struct {
+	Files []struct {
+		URI    string `json:"uri"`
+		Size   int64  `json:"size"`
+		Sha2   string `json:"sha2"`
+		Folder bool   `json:"folder"`
+	} `json:"files"`
+}
+
+func (c *RepoCache) storageList(distro, version string) (map[string]CachedFile, error) {
+	repoPath := path.Join(c.repo, distro, version)
+	resp, err := c.client.Get(fmt.Sprintf("%s/api/storage/%s?list&deep=0&listFolders=0", c.baseURL, repoPath))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return map[string]CachedFile{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to list %s: %s", repoPath, resp.Status)
+	}
+	var list storageFileList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("unable to parse storage list of %s: %v", repoPath, err)
+	}
+	files := make(map[string]CachedFile)
+	for _, f := range list.Files {
+		if f.Folder {
+			continue
+		}
+		name := path.Base(f.URI)
+		files[name] = CachedFile{
+			URL:    c.baseURL + "/" + path.Join(repoPath, name),
+			Sha256: f.Sha2,
+			Size:   f.Size,
+		}
+	}
+	return files, nil
 }
 
 func getDistVersionFromPath(path string) (string, string, error) {
@@ -92,6 +272,74 @@ func getDistVersionFromPath(path string) (string, string, error) {
 	return pathEL[len(pathEL)-2], pathEL[len(pathEL)-1], nil
 }
 
+// ArtifactoryFile is a kernel source file stored in artifactory repository
+type ArtifactoryFile struct {
+	Distro   string
+	Version  string
+	Name     string
+	Path     string
+	Sha256   string
+	Size     int64
+	Modified time.Time
+}
+
+func (a *ArtifactoryManger) GetArtifactoryFiles(logger logger.Logger, repo string) ([]ArtifactoryFile, error) {
+	var files []ArtifactoryFile
+	params := artServices.NewSearchParams()
+	params.Recursive = true
+	params.Pattern = repo
+	reader, err := a.manager.SearchFiles(params)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	for currentResult := new(artUtils.ResultItem); reader.NextRecord(currentResult) == nil; currentResult = new(artUtils.ResultItem) {
+		distro, version, err := getDistVersionFromPath(currentResult.Path)
+		if err != nil {
+			logger.Errorf("can`t use this result: %s err: %v", currentResult.Path, err)
+			continue
+		}
+		modified, err := time.Parse(time.RFC3339, currentResult.Modified)
+		if err != nil {
+			// files with unknown age can't be pruned safely
+			return nil, fmt.Errorf("can`t parse modification time of %s: %v", currentResult.Name, err)
+		}
+		files = append(files, ArtifactoryFile{
+			Distro:   distro,
+			Version:  version,
+			Name:     currentResult.Name,
+			Path:     fmt.Sprintf("%s/%s/%s", currentResult.Repo, currentResult.Path, currentResult.Name),
+			Sha256:   currentResult.Sha256,
+			Size:     currentResult.Size,
+			Modified: modified,
+		})
+	}
+	if err := reader.GetError(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (a *ArtifactoryManger) DeleteFiles(paths []string) (int, error) {
+	deleted := 0
+	for _, path := range paths {
+		params := artServices.NewDeleteParams()
+		params.Pattern = path
+		params.Recursive = false
+		reader, err := a.manager.GetPathsToDelete(params)
+		if err != nil {
+			return deleted, err
+		}
+		count, err := a.manager.DeleteFiles(reader)
+		reader.Close()
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
 func (a *ArtifactoryManger) GetArtifactoryKernels(logger logger.Logger, repo string) (*artifactoryKernelCache, error) {
 	fileMap := make(map[string]string)
 	artKernels := &artifactoryKernelCache{}
@@ -0,0 +1,400 @@
+package diskimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	extSuperblockOffset = 1024
+	extMagic            = 0xEF53
+	extRootInode        = 2
+	extGoodOldInodeSize = 128
+	extIncompat64Bit    = 0x80
+	extExtentsFlag      = 0x80000
+	extInlineDataFlag   = 0x10000000
+	extExtentMagic      = 0xF30A
+	extMaxSymlinks      = 40
+
+	modeTypeMask = 0xF000
+	modeDir      = 0x4000
+	modeFile     = 0x8000
+	modeSymlink  = 0xA000
+)
+
+// ExtFS is read-only ext2/ext3/ext4 filesystem, it reads files directly from
+// the image so image doesn't have to be loop mounted
+type ExtFS struct {
+	r              io.ReaderAt
+	offset         int64
+	blockSize      int64
+	inodesPerGroup uint32
+	inodeSize      int64
+	descSize       int64
+	descTable      int64
+}
+
+type extInode struct {
+	mode  uint16
+	size  int64
+	flags uint32
+	block []byte
+}
+
+// OpenExtFS opens ext filesystem starting at offset of r
+func OpenExtFS(r io.ReaderAt, offset int64) (*ExtFS, error) {
+	sb := make([]byte, 1024)
+	if _, err := r.ReadAt(sb, offset+extSuperblockOffset); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint16(sb[56:]) != extMagic {
+		return nil, fmt.Errorf("ext filesystem not found at offset %d", offset)
+	}
+	fs := &ExtFS{
+		r:              r,
+		offset:         offset,
+		blockSize:      1024 << binary.LittleEndian.Uint32(sb[24:]),
+		inodesPerGroup: binary.LittleEndian.Uint32(sb[40:]),
+		inodeSize:      extGoodOldInodeSize,
+		descSize:       32,
+	}
+	if binary.LittleEndian.Uint32(sb[76:]) >= 1 {
+		fs.inodeSize = int64(binary.LittleEndian.Uint16(sb[88:]))
+	}
+	if binary.LittleEndian.Uint32(sb[96:])&extIncompat64Bit != 0 {
+		fs.descSize = int64(binary.LittleEndian.Uint16(sb[254:]))
+	}
+	firstDataBlock := int64(binary.LittleEndian.Uint32(sb[20:]))
+	fs.descTable = (firstDataBlock + 1) * fs.blockSize
+	return fs, nil
+}
+
+// OpenImage opens the first ext filesystem found in partitions of disk image
+func OpenImage(f *os.File) (*ExtFS, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := Partitions(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range partitions {
+		if fs, err := OpenExtFS(f, p.Offset); err == nil {
+			return fs, nil
+		}
+	}
+	return nil, fmt.Errorf("ext filesystem not found in %s", f.Name())
+}
+
+func (fs *ExtFS) readAt(p []byte, off int64) error {
+	_, err := fs.r.ReadAt(p, fs.offset+off)
+	return err
+}
+
+func (fs *ExtFS) inode(n uint32) (*extInode, error) {
+	group := int64((n - 1) / fs.inodesPerGroup)
+	index := int64((n - 1) % fs.inodesPerGroup)
+	desc := make([]byte, fs.descSize)
+	if err := fs.readAt(desc, fs.descTable+group*fs.descSize); err != nil {
+		return nil, err
+	}
+	table := int64(binary.LittleEndian.Uint32(desc[8:]))
+	if fs.descSize >= 64 {
+		table |= int64(binary.LittleEndian.Uint32(desc[0x28:])) << 32
+	}
+	raw := make([]byte, extGoodOldInodeSize)
+	if err := fs.readAt(raw, table*fs.blockSize+index*fs.inodeSize); err != nil {
+		return nil, err
+	}
+	return &extInode{
+		mode:  binary.LittleEndian.Uint16(raw[0:]),
+		size:  int64(binary.LittleEndian.Uint32(raw[4:])) | int64(binary.LittleEndian.Uint32(raw[0x6C:]))<<32,
+		flags: binary.LittleEndian.Uint32(raw[0x20:]),
+		block: raw[0x28 : 0x28+60],
+	}, nil
+}
+
+// blocks returns physical blocks of inode data in logical order, 0 is a hole
+func (fs *ExtFS) blocks(ino *extInode) ([]int64, error) {
+	count := (ino.size + fs.blockSize - 1) / fs.blockSize
+	blocks := make([]int64, count)
+	if ino.flags&extExtentsFlag != 0 {
+		return blocks, fs.extentBlocks(ino.block, blocks)
+	}
+	return blocks, fs.mappedBlocks(ino.block, blocks)
+}
+
+// extentBlocks walks ext4 extent tree
+func (fs *ExtFS) extentBlocks(node []byte, blocks []int64) error {
+	if binary.LittleEndian.Uint16(node[0:]) != extExtentMagic {
+		return fmt.Errorf("invalid extent header")
+	}
+	entries := int(binary.LittleEndian.Uint16(node[2:]))
+	depth := binary.LittleEndian.Uint16(node[6:])
+	for i := 0; i < entries; i++ {
+		entry := node[12+i*12 : 24+i*12]
+		if depth > 0 {
+			leaf := int64(binary.LittleEndian.Uint32(entry[4:])) | int64(binary.LittleEndian.Uint16(entry[8:]))<<32
+			child := make([]byte, fs.blockSize)
+			if err := fs.readAt(child, leaf*fs.blockSize); err != nil {
+				return err
+			}
+			if err := fs.extentBlocks(child, blocks); err != nil {
+				return err
+			}
+			continue
+		}
+		logical := int64(binary.LittleEndian.Uint32(entry[0:]))
+		length := int64(binary.LittleEndian.Uint16(entry[4:]))
+		if length > 32768 {
+			// uninitialized extent reads as zeros
+			continue
+		}
+		start := int64(binary.LittleEndian.Uint16(entry[6:]))<<32 | int64(binary.LittleEndian.Uint32(entry[8:]))
+		for j := int64(0); j < length && logical+j < int64(len(blocks)); j++ {
+			blocks[logical+j] = start + j
+		}
+	}
+	return nil
+}
+
+// mappedBlocks reads ext2/ext3 direct and indirect block map
+func (fs *ExtFS) mappedBlocks(block []byte, blocks []int64) error {
+	next := 0
+	for i := 0; i < 12 && next < len(blocks); i++ {
+		blocks[next] = int64(binary.LittleEndian.Uint32(block[i*4:]))
+		next++
+	}
+	for level := 1; level <= 3 && next < len(blocks); level++ {
+		var err error
+		next, err = fs.indirectBlocks(int64(binary.LittleEndian.Uint32(block[(11+level)*4:])), level, blocks, next)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *ExtFS) indirectBlocks(block int64, level int, blocks []int64, next int) (int, error) {
+	perBlock := int(fs.blockSize / 4)
+	if block == 0 {
+		skip := perBlock
+		for i := 1; i < level; i++ {
+			skip *= perBlock
+		}
+		return next + skip, nil
+	}
+	data := make([]byte, fs.blockSize)
+	if err := fs.readAt(data, block*fs.blockSize); err != nil {
+		return next, err
+	}
+	for i := 0; i < perBlock && next < len(blocks); i++ {
+		ptr := int64(binary.LittleEndian.Uint32(data[i*4:]))
+		if level == 1 {
+			blocks[next] = ptr
+			next++
+			continue
+		}
+		var err error
+		if next, err = fs.indirectBlocks(ptr, level-1, blocks, next); err != nil {
+			return next, err
+		}
+	}
+	return next, nil
+}
+
+// copyData writes data of inode to w
+func (fs *ExtFS) copyData(w io.Writer, ino *extInode) error {
+	if ino.flags&extInlineDataFlag != 0 {
+		return fmt.Errorf("inline data is not supported")
+	}
+	blocks, err := fs.blocks(ino)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, fs.blockSize)
+	remaining := ino.size
+	for _, block := range blocks {
+		n := fs.blockSize
+		if remaining < n {
+			n = remaining
+		}
+		if block == 0 {
+			for i := range buf[:n] {
+				buf[i] = 0
+			}
+		} else if err := fs.readAt(buf[:n], block*fs.blockSize); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
+
+func (fs *ExtFS) readData(ino *extInode) ([]byte, error) {
+	var b bytes.Buffer
+	if err := fs.copyData(&b, ino); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// readDir returns inodes of directory entries indexed by name, hashed
+// directories are read linearly as their index nodes look like empty entries
+func (fs *ExtFS) readDir(ino *extInode) (map[string]uint32, error) {
+	data, err := fs.readData(ino)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]uint32)
+	for off := 0; off+8 <= len(data); {
+		inode := binary.LittleEndian.Uint32(data[off:])
+		recLen := int(binary.LittleEndian.Uint16(data[off+4:]))
+		nameLen := int(data[off+6])
+		if recLen < 8 || off+8+nameLen > len(data) {
+			return nil, fmt.Errorf("invalid directory entry")
+		}
+		name := string(data[off+8 : off+8+nameLen])
+		if inode != 0 && name != "." && name != ".." {
+			entries[name] = inode
+		}
+		off += recLen
+	}
+	return entries, nil
+}
+
+func (fs *ExtFS) readlink(ino *extInode) (string, error) {
+	if ino.size < 60 && ino.flags&(extExtentsFlag|extInlineDataFlag) == 0 {
+		return string(ino.block[:ino.size]), nil
+	}
+	data, err := fs.readData(ino)
+	return string(data), err
+}
+
+// resolve returns inode and canonical path of file, symlinks are followed
+// within the image
+func (fs *ExtFS) resolve(name string, follow bool, hops int) (uint32, string, error) {
+	current := uint32(extRootInode)
+	currentPath := "/"
+	components := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	for i, component := range components {
+		if component == "" {
+			continue
+		}
+		dir, err := fs.inode(current)
+		if err != nil {
+			return 0, "", err
+		}
+		if dir.mode&modeTypeMask != modeDir {
+			return 0, "", fmt.Errorf("%s is not a directory", currentPath)
+		}
+		entries, err := fs.readDir(dir)
+		if err != nil {
+			return 0, "", err
+		}
+		next, ok := entries[component]
+		if !ok {
+			return 0, "", fmt.Errorf("%s not found in image", path.Join(currentPath, component))
+		}
+		nextPath := path.Join(currentPath, component)
+		ino, err := fs.inode(next)
+		if err != nil {
+			return 0, "", err
+		}
+		last := i == len(components)-1
+		if ino.mode&modeTypeMask == modeSymlink && (!last || follow) {
+			if hops >= extMaxSymlinks {
+				return 0, "", fmt.Errorf("too many levels of symbolic links in %s", name)
+			}
+			target, err := fs.readlink(ino)
+			if err != nil {
+				return 0, "", err
+			}
+			if !path.IsAbs(target) {
+				target = path.Join(currentPath, target)
+			}
+			rest := path.Join(append([]string{target}, components[i+1:]...)...)
+			return fs.resolve(rest, follow, hops+1)
+		}
+		current = next
+		currentPath = nextPath
+	}
+	return current, currentPath, nil
+}
+
+// Extract copies directory src of the image to dest. Symlinks pointing inside
+// src are kept, other symlinks are replaced with their targets so extracted
+// tree doesn't depend on the rest of the image.
+func (fs *ExtFS) Extract(src, dest string) error {
+	inode, srcPath, err := fs.resolve(src, true, 0)
+	if err != nil {
+		return err
+	}
+	return fs.extract(inode, srcPath, srcPath, dest, map[uint32]bool{})
+}
+
+func (fs *ExtFS) extract(n uint32, imagePath, root, dest string, parents map[uint32]bool) error {
+	ino, err := fs.inode(n)
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(ino.mode & 0777)
+	switch ino.mode & modeTypeMask {
+	case modeDir:
+		if parents[n] {
+			return fmt.Errorf("directory loop at %s", imagePath)
+		}
+		parents[n] = true
+		defer delete(parents, n)
+		if err := os.MkdirAll(dest, perm|0700); err != nil {
+			return err
+		}
+		entries, err := fs.readDir(ino)
+		if err != nil {
+			return err
+		}
+		for name, child := range entries {
+			if err := fs.extract(child, path.Join(imagePath, name), root, filepath.Join(dest, name), parents); err != nil {
+				return err
+			}
+		}
+		return nil
+	case modeFile:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fs.copyData(f, ino)
+	case modeSymlink:
+		target, err := fs.readlink(ino)
+		if err != nil {
+			return err
+		}
+		resolved := target
+		if !path.IsAbs(resolved) {
+			resolved = path.Join(path.Dir(imagePath), resolved)
+		}
+		if !path.IsAbs(target) && (resolved == root || strings.HasPrefix(resolved, root+"/")) {
+			return os.Symlink(target, dest)
+		}
+		targetInode, targetPath, err := fs.resolve(resolved, true, 0)
+		if err != nil {
+			// dangling symlinks are kept as they are
+			return os.Symlink(target, dest)
+		}
+		return fs.extract(targetInode, targetPath, root, dest, parents)
+	}
+	// devices, fifos and sockets are not needed to build modules
+	return nil
+}
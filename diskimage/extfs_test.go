@@ -0,0 +1,180 @@
+package diskimage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const extIndexFlag = 0x1000
+
+// writeFixture creates tree copied to the image: kernel build directory
+// /usr/src/build reached through /lib/modules/5.15.0/build symlink, with
+// header outside of it
+func writeFixture(t *testing.T, root string) map[string][]byte {
+	files := map[string][]byte{
+		"usr/include/outside.h":  []byte("#define OUTSIDE 1\n"),
+		"usr/src/build/Makefile": []byte("obj-m += vrouter.o\n"),
+		// 300 KiB with 1 KiB blocks needs direct, single and double indirect blocks
+		"usr/src/build/big.bin": bytes.Repeat([]byte("0123456789abcdef"), 300*64),
+	}
+	for i := 0; i < 400; i++ {
+		files[fmt.Sprintf("usr/src/build/many/header-file-with-long-name-%03d.h", i)] = []byte(fmt.Sprintf("%d\n", i))
+	}
+	for name, data := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlinks := map[string]string{
+		"lib/modules/5.15.0/build":    "../../../usr/src/build",
+		"usr/src/build/inside":        "Makefile",
+		"usr/src/build/inside-long":   strings.Repeat("./", 40) + "Makefile",
+		"usr/src/build/outside.h":     "../../include/outside.h",
+		"usr/src/build/absolute.h":    "/usr/include/outside.h",
+		"usr/src/build/include":       "../../include",
+		"usr/src/build/dangling":      "missing",
+		"usr/src/build/many/makefile": "../Makefile",
+	}
+	for name, target := range symlinks {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+// makeImage creates ext filesystem image populated from root, directories
+// are indexed by e2fsck so large ones become htree directories
+func makeImage(t *testing.T, mkfs, root string, args ...string) string {
+	for _, tool := range []string{mkfs, "e2fsck"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	image := filepath.Join(t.TempDir(), "image")
+	args = append(args, "-q", "-F", "-b", "1024", "-d", root, image, "8M")
+	if out, err := exec.Command(mkfs, args...).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v\n%s", mkfs, err, out)
+	}
+	// exit code 1 means file system was modified
+	if out, err := exec.Command("e2fsck", "-f", "-y", "-D", image).CombinedOutput(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() > 1 {
+			t.Fatalf("e2fsck: %v\n%s", err, out)
+		}
+	}
+	return image
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		mkfs    string
+		args    []string
+		extents bool
+	}{
+		{name: "ext2 block map", mkfs: "mkfs.ext2", args: []string{"-O", "dir_index"}},
+		{name: "ext4 extents", mkfs: "mkfs.ext4", args: []string{"-O", "^inline_data"}, extents: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			files := writeFixture(t, root)
+			f, err := os.Open(makeImage(t, tt.mkfs, root, tt.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			fs, err := OpenExtFS(f, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// fixture has to exercise both block mapping and htree
+			for name, flag := range map[string]uint32{"/usr/src/build/big.bin": extExtentsFlag, "/usr/src/build/many": extIndexFlag} {
+				n, _, err := fs.resolve(name, true, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ino, err := fs.inode(n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := flag == extIndexFlag || tt.extents
+				if got := ino.flags&flag != 0; got != want {
+					t.Fatalf("%s flags %#x, want flag %#x %v", name, ino.flags, flag, want)
+				}
+			}
+
+			dest := filepath.Join(t.TempDir(), "build")
+			if err := fs.Extract("/lib/modules/5.15.0/build", dest); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range files {
+				if !strings.HasPrefix(name, "usr/src/build/") {
+					continue
+				}
+				got, err := os.ReadFile(filepath.Join(dest, strings.TrimPrefix(name, "usr/src/build/")))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("%s: content differs, got %d bytes, want %d", name, len(got), len(data))
+				}
+			}
+			// symlinks inside of src are kept, symlinks outside are replaced
+			// with their targets
+			for name, target := range map[string]string{
+				"inside":        "Makefile",
+				"inside-long":   strings.Repeat("./", 40) + "Makefile",
+				"many/makefile": "../Makefile",
+				"dangling":      "missing",
+			} {
+				got, err := os.Readlink(filepath.Join(dest, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != target {
+					t.Errorf("%s: got symlink to %s, want %s", name, got, target)
+				}
+			}
+			for _, name := range []string{"outside.h", "absolute.h", "include/outside.h"} {
+				p := filepath.Join(dest, name)
+				if info, err := os.Lstat(p); err != nil || info.Mode()&os.ModeSymlink != 0 {
+					t.Fatalf("%s: want regular file, got %v %v", name, info, err)
+				}
+				if got, _ := os.ReadFile(p); !bytes.Equal(got, files["usr/include/outside.h"]) {
+					t.Errorf("%s: got %q", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractNotFound(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root)
+	f, err := os.Open(makeImage(t, "mkfs.ext4", root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fs, err := OpenExtFS(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Extract("/lib/modules/6.1.0/build", t.TempDir()); err == nil {
+		t.Fatal("expected error")
+	}
+}
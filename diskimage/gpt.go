@@ -0,0 +1,57 @@
+package diskimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	gptSignature = "EFI PART"
+	gptEntryLBA  = 72
+	gptEntries   = 80
+	gptEntrySize = 84
+)
+
+// Partition is a partition of GPT disk image
+type Partition struct {
+	Offset int64
+	Size   int64
+}
+
+// Partitions returns partitions of GPT disk image, image without partition
+// table is returned as a single partition covering whole image
+func Partitions(r io.ReaderAt, size int64) ([]Partition, error) {
+	for _, sectorSize := range []int64{512, 4096} {
+		header := make([]byte, 92)
+		if _, err := r.ReadAt(header, sectorSize); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if !bytes.Equal(header[:8], []byte(gptSignature)) {
+			continue
+		}
+		entryLBA := int64(binary.LittleEndian.Uint64(header[gptEntryLBA:]))
+		entries := int64(binary.LittleEndian.Uint32(header[gptEntries:]))
+		entrySize := int64(binary.LittleEndian.Uint32(header[gptEntrySize:]))
+		table := make([]byte, entries*entrySize)
+		if _, err := r.ReadAt(table, entryLBA*sectorSize); err != nil {
+			return nil, err
+		}
+		var partitions []Partition
+		for i := int64(0); i < entries; i++ {
+			entry := table[i*entrySize : (i+1)*entrySize]
+			if bytes.Equal(entry[:16], make([]byte, 16)) {
+				// unused entry
+				continue
+			}
+			first := int64(binary.LittleEndian.Uint64(entry[32:]))
+			last := int64(binary.LittleEndian.Uint64(entry[40:]))
+			partitions = append(partitions, Partition{Offset: first * sectorSize, Size: (last - first + 1) * sectorSize})
+		}
+		return partitions, nil
+	}
+	return []Partition{{Offset: 0, Size: size}}, nil
+}
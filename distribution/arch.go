@@ -0,0 +1,84 @@
+package distribution
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+const (
+	ARCH_X86_64  = "x86_64"
+	ARCH_AARCH64 = "aarch64"
+	// ARCH_PLACEHOLDER in parser is replaced with rpm arch of version
+	// (x86_64, aarch64), DEB_ARCH_PLACEHOLDER with Debian arch (amd64, arm64)
+	ARCH_PLACEHOLDER     = "{arch}"
+	DEB_ARCH_PLACEHOLDER = "{debarch}"
+)
+
+// archInfo holds names of architecture used by packages and kernel build
+type archInfo struct {
+	goArch       string
+	debArch      string
+	kernelArch   string
+	crossCompile string
+}
+
+var archs = map[string]archInfo{
+	ARCH_X86_64:  {goArch: "amd64", debArch: "amd64", kernelArch: "x86_64", crossCompile: "x86_64-linux-gnu-"},
+	ARCH_AARCH64: {goArch: "arm64", debArch: "arm64", kernelArch: "arm64", crossCompile: "aarch64-linux-gnu-"},
+}
+
+// arch returns architecture of kernels of version, x86_64 by default
+func (v Version) arch() string {
+	if v.Arch == "" {
+		return ARCH_X86_64
+	}
+	return v.Arch
+}
+
+func (v Version) validateArch() error {
+	if _, ok := archs[v.arch()]; !ok {
+		return fmt.Errorf("unsupported arch %s of version %s", v.Arch, v.Name)
+	}
+	return nil
+}
+
+func (k *Kernel) arch() string {
+	if k.Arch == "" {
+		return ARCH_X86_64
+	}
+	return k.Arch
+}
+
+// debArch returns Debian name of kernel architecture
+func (k *Kernel) debArch() string {
+	return archs[k.arch()].debArch
+}
+
+// kernelArch returns kernel name of architecture used by ARCH make variable
+// and SUSE build directories
+func (k *Kernel) kernelArch() string {
+	return archs[k.arch()].kernelArch
+}
+
+// DirName returns name of directory with kernel sources, kernels of other
+// architecture than x86_64 have arch suffix so they don't collide
+func (k *Kernel) DirName() string {
+	if k.arch() == ARCH_X86_64 {
+		return k.Name
+	}
+	return k.Name + "." + k.arch()
+}
+
+// crossCompileEnv returns make variables for cross compilation when kernel
+// architecture differs from host, installed cross toolchain is required
+func (k *Kernel) crossCompileEnv() ([]string, error) {
+	info := archs[k.arch()]
+	if info.goArch == runtime.GOARCH {
+		return nil, nil
+	}
+	if _, err := exec.LookPath(info.crossCompile + "gcc"); err != nil {
+		return nil, fmt.Errorf("cross toolchain %sgcc for kernel %s not found: %v", info.crossCompile, k.Name, err)
+	}
+	return []string{"ARCH=" + info.kernelArch, "CROSS_COMPILE=" + info.crossCompile}, nil
+}
@@ -0,0 +1,141 @@
+package distribution
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/logger"
+)
+
+const (
+	// COREOS_RELEASE_META is metadata of coreos-assembler build relative to
+	// builds.json, %s is replaced with build id
+	COREOS_RELEASE_META = "%s/" + ARCH_PLACEHOLDER + "/commitmeta.json"
+	COREOS_PKGLIST      = "rpmostree.rpmdb.pkglist"
+)
+
+// coreosBuilds is builds.json of coreos-assembler release stream, newest build
+// is the first one
+type coreosBuilds struct {
+	Builds []struct {
+		ID string `json:"id"`
+	} `json:"builds"`
+}
+
+// coreosPackage is package of OS image, kernel version is pinned by OS build
+type coreosPackage struct {
+	Name    string
+	Epoch   string
+	Version string
+	Release string
+	Arch    string
+}
+
+func (p coreosPackage) develFileName() string {
+	return fmt.Sprintf("%s-devel-%s-%s.%s.rpm", p.Name, p.Version, p.Release, p.Arch)
+}
+
+// isReleaseStream checks if kernels of distribution are pinned by OS releases
+// listed in release stream instead of being discovered in repository
+func (d *Distribution) isReleaseStream() bool {
+	return d.Name == string(FCOS) || d.Name == string(RHCOS) || d.Name == string(FLATCAR)
+}
+
+// getReleaseStreamKernelFiles reads kernel of every OS release of Fedora
+// CoreOS or RHCOS release stream (builds.json of coreos-assembler) and
+// returns kernel-devel files from BaseURL together with OS releases shipping
+// every kernel
+func (d *Distribution) getReleaseStreamKernelFiles(client *http.Client, logger logger.Logger, version Version) (map[string][]string, map[string][]string, error) {
+	var builds coreosBuilds
+	if err := readJSON(client, version.ReleaseStream, &builds); err != nil {
+		return nil, nil, err
+	}
+	releaseMeta := version.ReleaseMetaURL
+	if releaseMeta == "" {
+		releaseMeta = version.ReleaseStream[:strings.LastIndex(version.ReleaseStream, "/")+1] + COREOS_RELEASE_META
+	}
+	releaseMeta = strings.ReplaceAll(releaseMeta, ARCH_PLACEHOLDER, version.arch())
+	kernelMap := make(map[string][]string)
+	osReleases := make(map[string][]string)
+	for i, build := range builds.Builds {
+		if version.MaxReleases > 0 && i >= version.MaxReleases {
+			break
+		}
+		kernel, err := coreosKernel(client, fmt.Sprintf(releaseMeta, build.ID))
+		if err != nil {
+			return nil, nil, err
+		}
+		if kernel == nil {
+			logger.Errorf("kernel not found in %s release %s", d.Name, build.ID)
+			continue
+		}
+		releaseVersion := version
+		releaseVersion.BaseURL = kernelRepoURL(version.BaseURL, *kernel)
+		releaseKernels, err := d.parse([]string{kernel.develFileName()}, releaseVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		for kernelName, files := range releaseKernels {
+			if _, ok := kernelMap[kernelName]; !ok {
+				kernelMap[kernelName] = files
+			}
+			osReleases[kernelName] = append(osReleases[kernelName], build.ID)
+		}
+	}
+	return kernelMap, osReleases, nil
+}
+
+// coreosKernel returns kernel package listed in commit metadata of OS build
+func coreosKernel(client *http.Client, source string) (*coreosPackage, error) {
+	var meta map[string]json.RawMessage
+	if err := readJSON(client, source, &meta); err != nil {
+		return nil, err
+	}
+	var pkgList [][]string
+	if err := json.Unmarshal(meta[COREOS_PKGLIST], &pkgList); err != nil {
+		return nil, fmt.Errorf("unable to read %s of %s: %v", COREOS_PKGLIST, source, err)
+	}
+	for _, p := range pkgList {
+		if len(p) == 5 && p[0] == "kernel" {
+			return &coreosPackage{Name: p[0], Epoch: p[1], Version: p[2], Release: p[3], Arch: p[4]}, nil
+		}
+	}
+	return nil, nil
+}
+
+// kernelRepoURL replaces {version}, {release} and {arch} in repository url
+// with kernel package EVR, e.g. for koji package layout
+func kernelRepoURL(baseURL string, p coreosPackage) string {
+	return strings.NewReplacer("{version}", p.Version, "{release}", p.Release, "{arch}", p.Arch).Replace(baseURL)
+}
+
+// readJSON decodes json document from url or local file
+func readJSON(client *http.Client, source string, v interface{}) error {
+	var r io.Reader
+	if isRemote(source) {
+		response, err := client.Get(source)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s for %s", response.Status, source)
+		}
+		r = response.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode %s: %v", source, err)
+	}
+	return nil
+}
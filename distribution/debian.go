@@ -0,0 +1,80 @@
+package distribution
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Debian splits kernel headers into flavour specific linux-headers-<abi>-<arch>,
+// linux-headers-<abi>-common shared by flavours and linux-kbuild-<major.minor>
+// with kbuild scripts and tools. Headers packages are matched with parser,
+// kbuild package is found by the package version of headers.
+var debianHeaders = regexp.MustCompile(`linux-headers-.+-(\w+)_(.+)_(\w+)\.deb$`)
+
+// addDebianKbuild adds linux-kbuild package to every kernel found in file list
+// of Debian pool, kernel without kbuild package can't be extracted
+func addDebianKbuild(kernelMap map[string][]string, fileList []string, version Version) {
+	debArch := archs[version.arch()].debArch
+	kbuildFiles := make(map[string]string)
+	for _, file := range fileList {
+		fileName := filepath.Base(file)
+		if strings.HasPrefix(fileName, "linux-kbuild-") && strings.HasSuffix(fileName, "_"+debArch+".deb") {
+			kbuildFiles[fileName] = file
+		}
+	}
+	for kernelName, files := range kernelMap {
+		kbuild := debianKbuildName(kernelName, files, debArch)
+		if kbuild == "" {
+			continue
+		}
+		if _, ok := kbuildFiles[kbuild]; ok {
+			kernelMap[kernelName] = append(files, version.BaseURL+"/"+kbuild)
+		}
+	}
+}
+
+// debianKbuildName returns file name of linux-kbuild package built from the
+// same source package as kernel headers
+func debianKbuildName(kernelName string, files []string, debArch string) string {
+	kver := strings.SplitN(kernelName, ".", 3)
+	if len(kver) < 2 {
+		return ""
+	}
+	for _, file := range files {
+		match := debianHeaders.FindStringSubmatch(file)
+		if len(match) > 3 && match[1] == debArch && match[3] == debArch {
+			return fmt.Sprintf("linux-kbuild-%s.%s_%s_%s.deb", kver[0], kver[1], match[2], debArch)
+		}
+	}
+	return ""
+}
+
+// debianExtractCommand unpacks Debian packages into kernel directory without
+// installing them. Makefile of flavour headers includes common headers with
+// absolute /usr/src path which is redirected to kernel directory.
+func (k *Kernel) debianExtractCommand(kernelDir string) (string, error) {
+	var headers, common, kbuild bool
+	var commands []string
+	for _, kernelFile := range k.Files {
+		fileName, err := destFileName(kernelFile)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case strings.HasPrefix(fileName, "linux-kbuild-"):
+			kbuild = true
+		case strings.HasPrefix(fileName, "linux-headers-") && strings.HasSuffix(fileName, "_all.deb"):
+			common = true
+		case strings.HasPrefix(fileName, "linux-headers-"):
+			headers = true
+		}
+		commands = append(commands, fmt.Sprintf("dpkg-deb -x %s/%s %s", kernelDir, fileName, kernelDir))
+	}
+	if !headers || !common || !kbuild {
+		return "", fmt.Errorf("kernel %s requires linux-headers-%s, linux-headers-%s-common and linux-kbuild packages", k.Name, k.Name+k.LocalVersion, k.Name)
+	}
+	commands = append(commands, fmt.Sprintf("sed -i 's|/usr/src/|%s/usr/src/|g' %s/Makefile", kernelDir, k.KernelPath))
+	return strings.Join(commands, " && "), nil
+}
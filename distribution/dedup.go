@@ -0,0 +1,199 @@
+package distribution
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KernelGroup is a set of kernels with the same header tree, e.g. the same
+// package listed by overlapping versions or identical minikube kernels of
+// several tags. Only Kernel is downloaded and built, result is linked to
+// Shared kernels.
+type KernelGroup struct {
+	Key    string
+	Kernel *Kernel
+	Shared []*Kernel
+}
+
+// GroupKernels groups kernels by their content key keeping order of the first
+// kernel of every group. Configs and config fragments are read with client to
+// compare their content.
+func GroupKernels(client *http.Client, kernels []*Kernel) []*KernelGroup {
+	var groups []*KernelGroup
+	index := make(map[string]*KernelGroup)
+	configSums := make(map[string]string)
+	for _, kernel := range kernels {
+		kernel.ContentKey = kernel.contentKey(client, configSums)
+		if group, ok := index[kernel.ContentKey]; ok {
+			group.Shared = append(group.Shared, kernel)
+			continue
+		}
+		group := &KernelGroup{Key: kernel.ContentKey, Kernel: kernel}
+		index[kernel.ContentKey] = group
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Link copies download, extract and build results of the built kernel to
+// kernels sharing it
+func (g *KernelGroup) Link() {
+	for _, kernel := range g.Shared {
+		kernel.SharedBuild = g.Kernel.ID()
+		kernel.Downloaded = g.Kernel.Downloaded
+		kernel.Extracted = g.Kernel.Extracted
+		kernel.Compiled = g.Kernel.Compiled
+		kernel.Errormsg = g.Kernel.Errormsg
+		kernel.KernelPath = g.Kernel.KernelPath
+		kernel.Command = g.Kernel.Command
+		kernel.FileLocation = g.Kernel.FileLocation
+		kernel.Durations = g.Kernel.Durations
+	}
+}
+
+// ID returns distribution, version and name of kernel
+func (k *Kernel) ID() string {
+	return fmt.Sprintf("%s/%s/%s", k.Distro, k.DistroVersion, k.Name+k.LocalVersion)
+}
+
+// contentKey identifies header tree of kernel. When checksums of all kernel
+// packages are known the tree is identified by them, otherwise by kernel
+// release and package names within distribution. Content of config, config
+// fragments and custom config are part of the key, so kernels built from the
+// same sources with different configs are not shared. Checksums of configs
+// are kept in configSums indexed by their source.
+func (k *Kernel) contentKey(client *http.Client, configSums map[string]string) string {
+	lines := []string{"arch " + k.arch()}
+	if checksums := k.fileChecksums(); checksums != nil {
+		lines = append(lines, checksums...)
+	} else {
+		lines = append(lines, fmt.Sprintf("release %s %s", k.Distro, k.Name+k.LocalVersion))
+		for _, kernelFile := range k.Files {
+			if fileName, err := destFileName(kernelFile); err == nil && k.isConfigFile(fileName) {
+				lines = append(lines, fmt.Sprintf("file %s %s", fileName, k.configSum(client, configSums, kernelFile)))
+				continue
+			}
+			lines = append(lines, "file "+packageFileKey(kernelFile))
+		}
+	}
+	// downloaded configs are identified by files above and relative ones are
+	// part of the sources, local files are identified by content
+	configKey := func(source string) string {
+		if filepath.IsAbs(source) {
+			return k.configSum(client, configSums, source)
+		}
+		return source
+	}
+	lines = append(lines, "localversion "+k.LocalVersion, "config "+configKey(k.ConfigSource))
+	for i, fragment := range k.ConfigFragments {
+		// fragments are merged in order
+		lines = append(lines, fmt.Sprintf("fragment %d %s", i, configKey(fragment)))
+	}
+	for option, value := range k.CustomConfig {
+		lines = append(lines, fmt.Sprintf("custom %s=%s", option, value))
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// isConfigFile checks if downloaded file is config or config fragment
+func (k *Kernel) isConfigFile(fileName string) bool {
+	if fileName == KERNEL_CONFIG_FILE || fileName == k.ConfigSource {
+		return true
+	}
+	for _, fragment := range k.ConfigFragments {
+		if fileName == fragment {
+			return true
+		}
+	}
+	return false
+}
+
+// configSum returns sha256 of config read from url or local file. Config
+// which can't be read is identified by kernel, so the kernel is not shared.
+func (k *Kernel) configSum(client *http.Client, configSums map[string]string, source string) string {
+	if sum, ok := configSums[source]; ok {
+		return sum
+	}
+	h := sha256.New()
+	var err error
+	if isRemote(source) {
+		err = readURL(client, source, h)
+	} else {
+		err = readFile(source, h)
+	}
+	if err != nil {
+		return fmt.Sprintf("unreadable %s (%v) %s", source, err, k.ID())
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	configSums[source] = sum
+	return sum
+}
+
+func readURL(client *http.Client, source string, w io.Writer) error {
+	response, err := client.Get(source)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s for %s", response.Status, source)
+	}
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+func readFile(source string, w io.Writer) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// fileChecksums returns checksums of all kernel files, nil if checksum of any
+// of them is unknown
+func (k *Kernel) fileChecksums() []string {
+	if len(k.Files) == 0 {
+		return nil
+	}
+	var checksums []string
+	for _, kernelFile := range k.Files {
+		fileName, err := destFileName(kernelFile)
+		if err != nil {
+			return nil
+		}
+		checksum := k.Checksums[fileName]
+		if checksum == "" {
+			return nil
+		}
+		checksums = append(checksums, "checksum "+checksum)
+	}
+	return checksums
+}
+
+// packageFileKey returns name of distribution package which identifies its
+// content regardless of mirror, other files (configs, tarballs) are
+// identified by url
+func packageFileKey(kernelFile string) string {
+	fileName, err := destFileName(kernelFile)
+	if err != nil {
+		return kernelFile
+	}
+	switch path.Ext(fileName) {
+	case ".rpm", ".deb":
+		return fileName
+	}
+	return kernelFile
+}
@@ -0,0 +1,105 @@
+package distribution
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGroupKernels(t *testing.T) {
+	configs := map[string]string{
+		"/a/config":   "CONFIG_X=y\n",
+		"/b/config":   "CONFIG_X=y\n",
+		"/c/config":   "CONFIG_X=n\n",
+		"/a/fragment": "CONFIG_Y=y\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, ok := configs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(config))
+	}))
+	defer server.Close()
+	localConfig := filepath.Join(t.TempDir(), "local.config")
+	if err := os.WriteFile(localConfig, []byte("CONFIG_X=y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	upstream := func(version string, files ...string) *Kernel {
+		return &Kernel{
+			Name:          "5.15.90",
+			Distro:        UPSTREAM,
+			DistroVersion: version,
+			Files:         append([]string{"https://cdn.kernel.org/pub/linux/kernel/v5.x/linux-5.15.90.tar.gz"}, files...),
+			ConfigSource:  KERNEL_CONFIG_FILE,
+		}
+	}
+	withFragment := func(k *Kernel) *Kernel {
+		k.ConfigFragments = []string{"fragment-0.config"}
+		return k
+	}
+	tests := []struct {
+		name    string
+		kernels []*Kernel
+		groups  int
+	}{
+		{
+			name:    "same config content at different urls",
+			kernels: []*Kernel{upstream("a", server.URL+"/a/config#linux_defconfig"), upstream("b", server.URL+"/b/config#linux_defconfig")},
+			groups:  1,
+		},
+		{
+			name:    "different config content",
+			kernels: []*Kernel{upstream("a", server.URL+"/a/config#linux_defconfig"), upstream("c", server.URL+"/c/config#linux_defconfig")},
+			groups:  2,
+		},
+		{
+			name:    "unreadable config is not shared",
+			kernels: []*Kernel{upstream("a", server.URL+"/missing#linux_defconfig"), upstream("b", server.URL+"/missing#linux_defconfig")},
+			groups:  2,
+		},
+		{
+			name: "fragment content",
+			kernels: []*Kernel{
+				withFragment(upstream("a", server.URL+"/a/config#linux_defconfig", server.URL+"/a/fragment#fragment-0.config")),
+				withFragment(upstream("b", server.URL+"/b/config#linux_defconfig", server.URL+"/c/config#fragment-0.config")),
+			},
+			groups: 2,
+		},
+		{
+			name: "local config content",
+			kernels: []*Kernel{
+				{Name: "5.15.90", Distro: UPSTREAM, DistroVersion: "a", Files: []string{"linux-5.15.90.tar.gz"}, ConfigSource: localConfig},
+				{Name: "5.15.90", Distro: UPSTREAM, DistroVersion: "b", Files: []string{"linux-5.15.90.tar.gz"}, ConfigSource: localConfig},
+				{Name: "5.15.90", Distro: UPSTREAM, DistroVersion: "c", Files: []string{"linux-5.15.90.tar.gz"}, ConfigSource: localConfig + ".missing"},
+			},
+			groups: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := GroupKernels(server.Client(), tt.kernels)
+			if len(groups) != tt.groups {
+				t.Errorf("got %d groups, want %d", len(groups), tt.groups)
+			}
+		})
+	}
+}
+
+func TestLink(t *testing.T) {
+	built := &Kernel{Name: "5.4.0-100", Distro: UBUNTU, DistroVersion: "20.04", Compiled: SUCCESS, Extracted: SUCCESS, Downloaded: SUCCESS}
+	built.Durations = map[string]time.Duration{PHASE_EXTRACT: time.Second, PHASE_COMPILE: time.Minute}
+	shared := &Kernel{Name: "5.4.0-100", Distro: UBUNTU, DistroVersion: "20.04.6"}
+	group := &KernelGroup{Kernel: built, Shared: []*Kernel{shared}}
+	group.Link()
+	if shared.SharedBuild != "ubuntu/20.04/5.4.0-100" || !shared.Compiled {
+		t.Errorf("got shared kernel %+v", shared)
+	}
+	if shared.Duration() != time.Minute+time.Second {
+		t.Errorf("got duration %v", shared.Duration())
+	}
+}
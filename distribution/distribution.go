@@ -2,12 +2,13 @@ package distribution
 
 import (
 	"archive/tar"
-	"bufio"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
@@ -17,9 +18,9 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Masterminds/semver"
-	"github.com/google/go-github/v39/github"
 	"github.com/hashicorp/go-retryablehttp"
 	"golang.org/x/net/html"
 
@@ -36,6 +37,17 @@ const (
 	CENTOS   Distro   = "centos"
 	RHEL     Distro   = "rhel"
 	MINIKUBE Distro   = "minikube"
+	UPSTREAM Distro   = "upstream"
+	DEBIAN   Distro   = "debian"
+	ROCKY    Distro   = "rocky"
+	ALMA     Distro   = "almalinux"
+	ORACLE   Distro   = "oraclelinux"
+	SLES     Distro   = "sles"
+	OPENSUSE Distro   = "opensuse"
+	AMAZON   Distro   = "amazonlinux"
+	FCOS     Distro   = "fcos"
+	RHCOS    Distro   = "rhcos"
+	FLATCAR  Distro   = "flatcar"
 	DEB      FileType = "deb"
 	RPM      FileType = "rpm"
 	TGZ      FileType = "tgz"
@@ -48,22 +60,54 @@ type Distributions struct {
 	ArtifactoryRepo string         `yaml:"artifactoryRepo"`
 }
 
+// Validate checks settings of all versions which can be checked without
+// discovery, so invalid config is rejected when it is loaded
+func (d Distributions) Validate() error {
+	for _, distro := range d.Distributions {
+		for _, version := range distro.Versions {
+			if err := version.validateArch(); err != nil {
+				return err
+			}
+			if err := version.validateIndexURL(); err != nil {
+				return fmt.Errorf("%s: %v", distro.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
 type Kernel struct {
-	Name             string
-	Files            []string
-	Distro           Distro
-	KernelPath       string
-	Compiled         Status
-	Errormsg         string
-	Downloaded       Status
-	Extracted        Status
-	DistroVersion    string
-	MinikubeVersions []string
-	LocalVersion     string
-	CustomConfig     map[string]string
-	Required         bool
-	Command          string
-	FileLocation     map[string]string
+	Name            string
+	Files           []string
+	Distro          Distro
+	KernelPath      string
+	Compiled        Status
+	Errormsg        string
+	Downloaded      Status
+	Extracted       Status
+	DistroVersion   string
+	Platforms       []PlatformRelease
+	LocalVersion    string
+	Flavor          string
+	Arch            string
+	CustomConfig    map[string]string
+	ConfigSource    string
+	ConfigFragments []string
+	Required        bool
+	Command         string
+	FileLocation    map[string]string
+	Checksums       map[string]string
+	UpstreamSha256  map[string]string // sha256 published by upstream by file name
+	Durations       map[string]time.Duration
+	ContentKey      string
+	SharedBuild     string
+	Size            int64
+	RhPackages      []RhPackage
+	Advisories      []Advisory
+	ContextDir      string            // build context of generated Dockerfile
+	ContextFiles    map[string]string // path in build context -> path in image
+	rhClient        *RhApiClient
+	downloadInfo    map[string]FileInfo
 }
 
 type Distribution struct {
@@ -71,26 +115,49 @@ type Distribution struct {
 	Versions         []Version `yaml:"versions"`
 	Parser           []string  `yaml:"parser"`
 	RequiredVersions []string  `yaml:"requiredVersions"`
+	fileCache        FileLister
+	minikube         *minikubeResolver
+}
+
+// FileLister is a kernel sources cache which can be listed with its API
+// instead of scraping html (artifactory, OCI registry). ListFiles returns
+// cached files indexed by file name.
+type FileLister interface {
+	ListFiles(distro, version string) (map[string]artifactory.CachedFile, error)
 }
 
 type Version struct {
-	Name               string         `yaml:"name"`
-	MinVersion         string         `yaml:"minVersion"`
-	MaxVersion         string         `yaml:"maxVersion"`
-	ExtraVersions      []string       `yaml:"extraVersions"`
-	BaseURL            string         `yaml:"baseURL"`
-	KernelURL          string         `yaml:"kernelURL"`
-	DefconfigURL       string         `yaml:"defconfigURL"`
-	KernelDefconfigURL string         `yaml:"kernelDeconfigURL"`
-	ArtifactoryCache   bool           `yaml:"artifactoryCache"`
-	RhRepository       string         `yaml:"rhRepository"`
-	CustomConfigs      []CustomConfig `yaml:"customConfigs"`
+	Name               string           `yaml:"name"`
+	MinVersion         string           `yaml:"minVersion"`
+	MaxVersion         string           `yaml:"maxVersion"`
+	ExtraVersions      []string         `yaml:"extraVersions"`
+	BaseURL            string           `yaml:"baseURL"`
+	VaultURL           string           `yaml:"vaultURL"`
+	IndexURL           string           `yaml:"indexURL"`
+	MirrorListURL      string           `yaml:"mirrorListURL"`
+	Flavor             string           `yaml:"flavor"`
+	Arch               string           `yaml:"arch"`
+	ReleaseStream      string           `yaml:"releaseStream"`
+	ReleaseMetaURL     string           `yaml:"releaseMetaURL"`
+	MaxReleases        int              `yaml:"maxReleases"`
+	KernelURL          string           `yaml:"kernelURL"`
+	DefconfigURL       string           `yaml:"defconfigURL"`
+	KernelDefconfigURL string           `yaml:"kernelDeconfigURL"`
+	MinikubeLayouts    []MinikubeLayout `yaml:"minikubeLayouts"`
+	ArtifactoryCache   bool             `yaml:"artifactoryCache"`
+	RhRepository       string           `yaml:"rhRepository"`
+	RhApiURL           string           `yaml:"rhApiURL"`
+	RhDiscovery        string           `yaml:"rhDiscovery"`
+	CustomConfigs      []CustomConfig   `yaml:"customConfigs"`
+	KernelConfig       string           `yaml:"kernelConfig"`
+	ConfigFragments    []string         `yaml:"configFragments"`
 }
 
 type CustomConfig struct {
 	KernelName         string            `yaml:"kernelName"`
 	LocalVersionSuffix string            `yaml:"localVersionSuffix"`
 	Properties         map[string]string `yaml:"properties"`
+	Fragments          []string          `yaml:"fragments"`
 }
 
 var gccMap = map[string]string{
@@ -99,8 +166,6 @@ var gccMap = map[string]string{
 	"3": "4.9",
 }
 
-var minikubeMap = make(map[string][]string)
-
 func GetHttpClientWithRetry(logger logger.LeveledLogger, retryNum int) *http.Client {
 	retryClient := retryablehttp.NewClient()
 	retryClient.RetryMax = retryNum
@@ -130,52 +195,77 @@ func downloadFile(client *http.Client, logger logger.Logger, filepath string, ur
 	return nil
 }
 
+// verifySha256 checks that file has the expected sha256 checksum
+func verifySha256(filePath, expected string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("sha256 of %s is %s, expected %s", filePath, actual, expected)
+	}
+	return nil
+}
+
 func (k *Kernel) Compile(logger logger.Logger) error {
+	defer k.addDuration(PHASE_COMPILE, time.Now())
 	var destKernelName string
 	if k.LocalVersion != "" {
 		destKernelName = k.Name + k.LocalVersion
 	} else {
 		destKernelName = k.Name
 	}
+	if err := os.MkdirAll(filepath.Dir(k.LogPath()), 0755); err != nil {
+		return err
+	}
+	buildLog, err := os.Create(k.LogPath())
+	if err != nil {
+		return err
+	}
+	defer buildLog.Close()
+	crossEnv, err := k.crossCompileEnv()
+	if err != nil {
+		// missing cross toolchain fails the build like compile error
+		k.Compiled = FAIL
+		k.Errormsg = err.Error()
+		fmt.Fprintln(buildLog, err)
+		return err
+	}
 	switch k.Distro {
-	case MINIKUBE:
-		logger.Infof("compiling kernel %s for minikube", destKernelName)
+	case MINIKUBE, UPSTREAM:
+		logger.Infof("compiling kernel %s for %s", destKernelName, k.Distro)
 		if err := os.Chdir(k.KernelPath); err != nil {
 			return err
 		}
 		// Copy fresh conifg
-		srcFile, err := os.Open("../linux_defconfig")
+		requested, err := k.prepareConfig()
 		if err != nil {
 			return err
 		}
-		defer srcFile.Close()
-		destFile, err := os.Create(k.KernelPath + "/.config")
-		if err != nil {
-			return err
-		}
-		defer destFile.Close()
-		if _, err := io.Copy(destFile, srcFile); err != nil {
+		makeOldConfig := []string{"make", "olddefconfig"}
+		if err := runCommand(logger, crossEnv, buildLog, makeOldConfig); err != nil {
 			return err
 		}
-
-		if k.CustomConfig != nil {
-			if err := prepareCustomConfig(logger, ".config", k.CustomConfig); err != nil {
-				return err
-			}
-		}
-		makeOldConfig := []string{"make", "olddefconfig"}
-		if err := runner(logger, makeOldConfig); err != nil {
+		if err := verifyKconfig(".config", requested); err != nil {
 			return err
 		}
 		make := []string{"make", "-j", strconv.Itoa(runtime.NumCPU()), "prepare", "headers_install", "scripts"}
-		if err := runner(logger, make); err != nil {
+		if err := runCommand(logger, crossEnv, buildLog, make); err != nil {
 			return err
 		}
 	}
-	kverList := strings.Split(k.Name, ".")
-	updateGCC := []string{"update-alternatives", "--set", "gcc", fmt.Sprintf("/usr/bin/gcc-%s", gccMap[kverList[0]])}
-	if err := runner(logger, updateGCC); err != nil {
-		return err
+	// cross toolchain is used as it is
+	if crossEnv == nil {
+		kverList := strings.Split(k.Name, ".")
+		updateGCC := []string{"update-alternatives", "--set", "gcc", fmt.Sprintf("/usr/bin/gcc-%s", gccMap[kverList[0]])}
+		if err := runCommand(logger, nil, buildLog, updateGCC); err != nil {
+			return err
+		}
 	}
 	if err := os.Remove("/tf-dev-env/vrouter/Module.symvers"); err != nil && !os.IsNotExist(err) {
 		return err
@@ -184,9 +274,9 @@ func (k *Kernel) Compile(logger logger.Logger) error {
 		return err
 	}
 
-	logger.Infof("compiling vrouter kernel module for kernel %s", destKernelName)
+	logger.Infof("compiling vrouter kernel module for kernel %s (%s)", destKernelName, k.arch())
 	scons := []string{"scons", fmt.Sprintf("--kernel-dir=%s", k.KernelPath), "--c++=c++11", "--opt=production", fmt.Sprintf("-j%d", runtime.NumCPU()), "vrouter/vrouter.ko"}
-	if err := runner(logger, scons); err != nil {
+	if err := runCommand(logger, crossEnv, buildLog, scons); err != nil {
 		k.Compiled = FAIL
 		k.Errormsg = fmt.Sprintf("%s", err)
 		logger.Errorf("failed to compile vrouter kernel module for kernel: %s", k.Name)
@@ -194,7 +284,7 @@ func (k *Kernel) Compile(logger logger.Logger) error {
 	} else {
 		k.Compiled = SUCCESS
 	}
-	if err := os.MkdirAll(fmt.Sprintf("/kernelmodules/%s", destKernelName), 0755); err != nil && !os.IsExist(err) {
+	if err := os.MkdirAll(filepath.Dir(k.ModulePath()), 0755); err != nil && !os.IsExist(err) {
 		return err
 	}
 	srcFile, err := os.Open("vrouter/vrouter.ko")
@@ -202,7 +292,7 @@ func (k *Kernel) Compile(logger logger.Logger) error {
 		return err
 	}
 	defer srcFile.Close()
-	destFile, err := os.Create(fmt.Sprintf("/kernelmodules/%s/vrouter.ko", destKernelName))
+	destFile, err := os.Create(k.ModulePath())
 	if err != nil {
 		return err
 	}
@@ -214,11 +304,23 @@ func (k *Kernel) Compile(logger logger.Logger) error {
 }
 
 func runner(logger logger.Logger, cmdList []string) error {
-	logger.Debugf("runnning: %v", cmdList)
+	return runCommand(logger, nil, nil, cmdList)
+}
+
+// runCommand runs command with variables added to the environment, command
+// and its output are written to build log when it is set
+func runCommand(logger logger.Logger, env []string, buildLog io.Writer, cmdList []string) error {
+	logger.Debugf("runnning: %v %v", env, cmdList)
 	cmd := exec.Command(cmdList[0], cmdList[1:]...)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	cmdOut, err := cmd.CombinedOutput()
 	// log limit 1MiB reached
 	// logger.Debugf("output: %s", string(cmdOut))
+	if buildLog != nil {
+		fmt.Fprintf(buildLog, "$ %s\n%s", strings.Join(append(append([]string{}, env...), cmdList...), " "), cmdOut)
+	}
 	if err != nil {
 		return fmt.Errorf("err %s %s", err, string(cmdOut))
 	}
@@ -230,10 +332,55 @@ func destFileName(fileURL string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// blob urls (e.g. OCI registry) keep file name in fragment
+	if u.Fragment != "" {
+		return filepath.Base(u.Fragment), nil
+	}
 	return filepath.Base(u.Path), nil
 }
 
+// EVR returns version-release of the kernel package based on package metadata
+// or its file name
+func (k *Kernel) EVR() string {
+	if len(k.RhPackages) > 0 {
+		return k.RhPackages[0].EVR()
+	}
+	for _, kernelFile := range k.Files {
+		fileName, err := destFileName(kernelFile)
+		if err != nil {
+			continue
+		}
+		if evr := packageEVR(fileName); evr != "" {
+			return evr
+		}
+	}
+	return ""
+}
+
+func packageEVR(fileName string) string {
+	switch filepath.Ext(fileName) {
+	case ".rpm":
+		// name-version-release.arch.rpm
+		nvr := strings.TrimSuffix(fileName, ".rpm")
+		nvr = strings.TrimSuffix(nvr, filepath.Ext(nvr))
+		parts := strings.Split(nvr, "-")
+		if len(parts) < 3 {
+			return ""
+		}
+		return parts[len(parts)-2] + "-" + parts[len(parts)-1]
+	case ".deb":
+		// name_version_arch.deb
+		parts := strings.Split(strings.TrimSuffix(fileName, ".deb"), "_")
+		if len(parts) != 3 {
+			return ""
+		}
+		return parts[1]
+	}
+	return ""
+}
+
 func (k *Kernel) Download(client *http.Client, logger logger.Logger, baseDir string) error {
+	defer k.addDuration(PHASE_DOWNLOAD, time.Now())
 	kernelDir := fmt.Sprintf("%s/%s/%s", baseDir, k.Distro, k.DistroVersion)
 	if err := os.MkdirAll(kernelDir, 0755); err != nil {
 		if !os.IsExist(err) {
@@ -247,10 +394,26 @@ func (k *Kernel) Download(client *http.Client, logger logger.Logger, baseDir str
 			return err
 		}
 		fileLocation := fmt.Sprintf("%s/%s", kernelDir, fileName)
-		if err := downloadFile(client, logger, fileLocation, kernelFile); err != nil {
+		fileURL, err := k.downloadURL(kernelFile)
+		if err != nil {
 			k.Downloaded = FAIL
 			return err
 		}
+		if err := downloadFile(client, logger, fileLocation, fileURL); err != nil {
+			k.Downloaded = FAIL
+			return err
+		}
+		if checksum, ok := k.UpstreamSha256[fileName]; ok {
+			if err := verifySha256(fileLocation, checksum); err != nil {
+				os.Remove(fileLocation)
+				k.Downloaded = FAIL
+				return err
+			}
+		}
+		if k.FileLocation == nil {
+			k.FileLocation = make(map[string]string)
+		}
+		k.FileLocation[fileLocation] = kernelFile
 		k.Downloaded = SUCCESS
 	}
 	return nil
@@ -263,12 +426,25 @@ func (k *Kernel) DownloadAndExtract(client *http.Client, logger logger.Logger) e
 			return err
 		}
 	}
-	kernelDir := fmt.Sprintf("%s/%s", baseKernelDir, k.Name)
+	kernelDir := fmt.Sprintf("%s/%s", baseKernelDir, k.DirName())
 	if err := os.Mkdir(kernelDir, 0755); err != nil {
 		if !os.IsExist(err) {
 			return err
 		}
 	}
+	if k.Distro == FLATCAR {
+		// build directory is extracted from developer container image here,
+		// there is no command to run
+		if err := k.extractFlatcar(client, logger, kernelDir); err != nil {
+			k.Extracted = FAIL
+			return err
+		}
+		k.Extracted = SUCCESS
+		return nil
+	}
+	// sources are downloaded and extracted by generated Dockerfile, only
+	// preparation of the build is timed here
+	defer k.addDuration(PHASE_EXTRACT, time.Now())
 	for _, kernelFile := range k.Files {
 		fileName, err := destFileName(kernelFile)
 		if err != nil {
@@ -276,10 +452,15 @@ func (k *Kernel) DownloadAndExtract(client *http.Client, logger logger.Logger) e
 			return err
 		}
 		fileLocation := fmt.Sprintf("%s/%s", kernelDir, fileName)
+		fileURL, err := k.downloadURL(kernelFile)
+		if err != nil {
+			k.Downloaded = FAIL
+			return err
+		}
 		if k.FileLocation == nil {
 			k.FileLocation = make(map[string]string)
 		}
-		k.FileLocation[fileLocation] = kernelFile
+		k.FileLocation[fileLocation] = fileURL
 		/*
 			if err := downloadFile(client, logger, fileLocation, kernelFile); err != nil {
 				k.Downloaded = FAIL
@@ -294,7 +475,7 @@ func (k *Kernel) DownloadAndExtract(client *http.Client, logger logger.Logger) e
 		}
 		switch fileExtension {
 		case ".gz":
-			k.Command = fmt.Sprintf("tar zxvf -C %s %s", kernelFile, fileLocation)
+			k.Command = fmt.Sprintf("tar zxvf %s -C %s", fileLocation, kernelDir)
 			/*
 				if err := extractTGZ(logger, kernelDir, fileLocation); err != nil {
 					k.Extracted = FAIL
@@ -303,13 +484,13 @@ func (k *Kernel) DownloadAndExtract(client *http.Client, logger logger.Logger) e
 			*/
 			k.Extracted = SUCCESS
 		case ".rpm":
-			r, err := regexp.Compile(`kernel-devel-(.+).(el\w+).x86_64.rpm`)
+			r, err := regexp.Compile(`kernel(?:-uek|\d+\.\d+)?-devel-(.+)\.(el\w+|amzn\w+|fc\d+)\.(x86_64|aarch64)\.rpm`)
 			if err != nil {
 				return err
 			}
 			version := r.FindStringSubmatch(fileLocation)
 			if len(version) > 1 {
-				k.KernelPath = fmt.Sprintf("%s/usr/src/kernels/%s.%s.x86_64", kernelDir, version[1], version[2])
+				k.KernelPath = fmt.Sprintf("%s/usr/src/kernels/%s.%s.%s", kernelDir, version[1], version[2], version[3])
 			}
 			/*
 				if err := os.Chdir(kernelDir); err != nil {
@@ -332,12 +513,16 @@ func (k *Kernel) DownloadAndExtract(client *http.Client, logger logger.Logger) e
 		}
 	}
 	switch k.Distro {
-	case MINIKUBE:
+	case MINIKUBE, UPSTREAM:
 		if k.Downloaded && k.Extracted {
 			k.KernelPath = fmt.Sprintf("%s/linux-%s", kernelDir, k.Name)
 		}
 	case UBUNTU:
 		if k.Downloaded {
+			if len(k.Files) < 2 {
+				k.Extracted = FAIL
+				return fmt.Errorf("kernel %s%s requires common and flavor headers packages", k.Name, k.LocalVersion)
+			}
 			installHeaders := []string{"dpkg", "-i", fmt.Sprintf("%s/%s", kernelDir, filepath.Base(k.Files[0])), fmt.Sprintf("%s/%s", kernelDir, filepath.Base(k.Files[1]))}
 			/*
 				logger.Infof("extracting %s and %s", filepath.Base(k.Files[0]), filepath.Base(k.Files[1]))
@@ -347,9 +532,81 @@ func (k *Kernel) DownloadAndExtract(client *http.Client, logger logger.Logger) e
 				}
 			*/
 			k.Extracted = SUCCESS
-			k.KernelPath = fmt.Sprintf("/usr/src/linux-headers-%s-generic", k.Name)
+			flavor := k.Flavor
+			if flavor == "" {
+				flavor = UBUNTU_FLAVOR
+			}
+			k.KernelPath = fmt.Sprintf("/usr/src/linux-headers-%s-%s", k.Name, flavor)
 			k.Command = strings.Join(installHeaders, " ")
 		}
+	case SLES, OPENSUSE:
+		if k.Downloaded {
+			k.KernelPath = fmt.Sprintf("%s/usr/src/linux-%s-obj/%s/default", kernelDir, k.Name, k.kernelArch())
+			command, err := k.suseExtractCommand(kernelDir)
+			if err != nil {
+				k.Extracted = FAIL
+				return err
+			}
+			k.Command = command
+			k.Extracted = SUCCESS
+		}
+	case DEBIAN:
+		if k.Downloaded {
+			k.KernelPath = fmt.Sprintf("%s/usr/src/linux-headers-%s%s", kernelDir, k.Name, k.LocalVersion)
+			command, err := k.debianExtractCommand(kernelDir)
+			if err != nil {
+				k.Extracted = FAIL
+				return err
+			}
+			k.Command = command
+			k.Extracted = SUCCESS
+		}
+	}
+	return nil
+}
+
+// ExtractOnHost downloads files of the kernel and runs the extract command
+// prepared by DownloadAndExtract on the host instead of generated Dockerfile,
+// so the kernel can be compiled by Compile
+func (k *Kernel) ExtractOnHost(client *http.Client, logger logger.Logger) error {
+	if !k.Downloaded || !k.Extracted {
+		return nil
+	}
+	if err := k.downloadFiles(client, logger); err != nil {
+		k.Downloaded = FAIL
+		return err
+	}
+	if k.Command == "" {
+		return nil
+	}
+	defer k.addDuration(PHASE_EXTRACT, time.Now())
+	var kernelDir string
+	for fileLocation := range k.FileLocation {
+		kernelDir = filepath.Dir(fileLocation)
+	}
+	logger.Infof("extracting kernel %s in %s", k.Name+k.LocalVersion, kernelDir)
+	if err := runner(logger, []string{"sh", "-c", fmt.Sprintf("cd %s && %s", kernelDir, k.Command)}); err != nil {
+		k.Extracted = FAIL
+		return err
+	}
+	return nil
+}
+
+// downloadFiles downloads kernel files to locations prepared by
+// DownloadAndExtract, the same checksums as by ADD of generated Dockerfile are
+// verified
+func (k *Kernel) downloadFiles(client *http.Client, logger logger.Logger) error {
+	defer k.addDuration(PHASE_DOWNLOAD, time.Now())
+	for fileLocation, fileURL := range k.FileLocation {
+		if err := downloadFile(client, logger, fileLocation, fileURL); err != nil {
+			return err
+		}
+		if checksum := k.Checksums[filepath.Base(fileLocation)]; checksum != "" {
+			if err := verifySha256(fileLocation, checksum); err != nil {
+				os.Remove(fileLocation)
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -360,7 +617,9 @@ func (d *Distribution) UseArtifactoryCache(artifactoryRepoUrl string) error {
 		return err
 	}
 	for i := range d.Versions {
-		if d.Versions[i].ArtifactoryCache {
+		// release stream kernels are discovered upstream and their files are
+		// looked up in file cache
+		if d.Versions[i].ArtifactoryCache && !d.isReleaseStream() {
 			path, err := url.Parse(fmt.Sprintf("%s/%s", d.Name, d.Versions[i].Name))
 			if err != nil {
 				return err
@@ -376,55 +635,94 @@ func (d *Distribution) UseArtifactoryCache(artifactoryRepoUrl string) error {
 	return nil
 }
 
-func hrefList(client *http.Client, baseURL string) ([]string, error) {
-	response, err := getHttpStringRespone(client, baseURL)
+func (d *Distribution) UseFileCache(fileCache FileLister) {
+	d.fileCache = fileCache
+}
+
+func (d *Distribution) cachedFileList(client *http.Client, version Version) (map[string][]string, map[string]artifactory.CachedFile, error) {
+	if d.fileCache == nil {
+		fileList, err := hrefList(client, version.BaseURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		downloadFileList, err := d.parse(fileList, version)
+		return downloadFileList, nil, err
+	}
+	files, err := d.fileCache.ListFiles(d.Name, version.Name)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	fileList, err := getFileList(response)
+	var fileList []string
+	for fileName := range files {
+		fileList = append(fileList, fileName)
+	}
+	downloadFileList, err := d.parse(fileList, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return downloadFileList, replaceCachedFiles(downloadFileList, files), nil
+}
+
+// useCachedFiles replaces urls of kernel files found in file cache with urls
+// of cached files, other files are downloaded from upstream
+func (d *Distribution) useCachedFiles(kernelMap map[string][]string, version Version) (map[string]artifactory.CachedFile, error) {
+	files, err := d.fileCache.ListFiles(d.Name, version.Name)
 	if err != nil {
 		return nil, err
 	}
+	return replaceCachedFiles(kernelMap, files), nil
+}
 
-	return fileList, nil
+// replaceCachedFiles replaces urls of kernel files with urls of cached files
+// indexed by file name and returns cached files indexed by url
+func replaceCachedFiles(kernelMap map[string][]string, files map[string]artifactory.CachedFile) map[string]artifactory.CachedFile {
+	fileInfo := make(map[string]artifactory.CachedFile)
+	for k, v := range kernelMap {
+		for i, fileURL := range v {
+			fileName, err := destFileName(fileURL)
+			if err != nil {
+				continue
+			}
+			if cachedFile, ok := files[fileName]; ok {
+				kernelMap[k][i] = cachedFile.URL
+				fileInfo[cachedFile.URL] = cachedFile
+			}
+		}
+	}
+	return fileInfo
 }
 
-func minikubeList(client *http.Client, baseURL string) ([]string, error) {
-	u, err := url.Parse(baseURL)
+func hrefList(client *http.Client, baseURL string) ([]string, error) {
+	response, err := getHttpStringRespone(client, baseURL)
 	if err != nil {
 		return nil, err
 	}
-	var fileList []string
-	if u.Host == "github.com" {
-		p := strings.Split(strings.Trim(u.Path, "/"), "/")
-		if len(p) < 2 {
-			return nil, fmt.Errorf("unable to find owner and repository in provided url: %s", baseURL)
-		}
-		fileList, err = getMinikubeTags(client, p[0], p[1])
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		fileList, err = hrefList(client, baseURL)
-		if err != nil {
-			return nil, err
-		}
+	fileList, err := getFileList(response)
+	if err != nil {
+		return nil, err
 	}
-	return fileList, nil
 
+	return fileList, nil
 }
 
-func (d *Distribution) GetKernelList(client *http.Client, logger logger.Logger, upstream bool, cachedKernels artifactory.ArtifactoryKernelCache) ([]*Kernel, error) {
+func (d *Distribution) GetKernelList(ctx context.Context, client *http.Client, logger logger.Logger, upstream bool, cachedKernels artifactory.ArtifactoryKernelCache) ([]*Kernel, error) {
 	var kernelList []*Kernel
+	var incompleteErr *IncompleteError
 	for _, version := range d.Versions {
+		if err := version.validateArch(); err != nil {
+			return nil, err
+		}
 		var downloadFileList map[string][]string
-		if !upstream && d.Name != string(MINIKUBE) && version.ArtifactoryCache {
+		var cachedFiles map[string]artifactory.CachedFile
+		var rhClient *RhApiClient
+		var rhPackageFiles map[string]RhPackage
+		var rhAdvisories map[string][]Advisory
+		var platforms map[string][]PlatformRelease
+		var upstreamChecksums map[string]string
+		if !upstream && d.Name != string(MINIKUBE) && !d.isReleaseStream() && version.ArtifactoryCache {
 			// Fetch from artifactory
-			fileList, err := hrefList(client, version.BaseURL)
-			if err != nil {
-				return nil, err
-			}
-			downloadFileList, err = d.parse(fileList, version)
+			var err error
+			downloadFileList, cachedFiles, err = d.cachedFileList(client, version)
 			if err != nil {
 				logger.Errorf("%v", err)
 				return nil, err
@@ -432,27 +730,65 @@ func (d *Distribution) GetKernelList(client *http.Client, logger logger.Logger,
 		} else {
 			switch d.Name {
 			case string(RHEL):
-				rhPackages, err := getRhelPackages(client, version.RhRepository, "kernel-devel")
-				if err != nil && len(rhPackages) < 1 {
-					return kernelList, err
+				rhClient = NewRhApiClient(client, version.RhApiURL, version.arch())
+				var rhPackages []RhPackage
+				var err error
+				switch version.RhDiscovery {
+				case RH_DISCOVERY_ERRATA:
+					rhPackages, rhAdvisories, err = rhClient.ListErrataPackages(ctx, version.RhRepository, "^kernel-devel$")
+				case RH_DISCOVERY_PACKAGES, "":
+					rhPackages, err = rhClient.ListPackages(ctx, version.RhRepository, "kernel-devel")
+				default:
+					return nil, fmt.Errorf("unknown rhDiscovery %s for version %s", version.RhDiscovery, version.Name)
+				}
+				if err != nil && !errors.As(err, &incompleteErr) {
+					return nil, err
 				}
 				if err != nil {
-					// Multiple request are done, but only some of them contain information about kernel packages
+					// continue with packages fetched so far, other versions
+					// are still discovered and results are reported as incomplete
 					logger.Errorf("RedHat package fetch error: %v", err)
 				}
-				downloadFileList, err = parseRedHatPackages(client, rhPackages, version, d.Parser, cachedKernels)
+				downloadFileList, rhPackageFiles, err = parseRedHatPackages(rhPackages, version, d.parsers(version))
 				if err != nil {
 					return kernelList, err
 				}
-			case string(UBUNTU), string(CENTOS):
-				fileList, err := hrefList(client, version.BaseURL)
+			case string(UBUNTU), string(CENTOS), string(UPSTREAM), string(DEBIAN), string(ROCKY), string(ALMA), string(ORACLE):
+				var err error
+				downloadFileList, err = d.mirrorFileList(client, logger, version)
 				if err != nil {
 					return nil, err
 				}
-				downloadFileList, err = d.parse(fileList, version)
+			case string(SLES), string(OPENSUSE), string(AMAZON):
+				var err error
+				downloadFileList, upstreamChecksums, err = d.repomdKernelFiles(client, logger, version)
 				if err != nil {
 					return nil, err
 				}
+			case string(FCOS), string(RHCOS):
+				var err error
+				var osReleases map[string][]string
+				downloadFileList, osReleases, err = d.getReleaseStreamKernelFiles(client, logger, version)
+				if err != nil {
+					return nil, err
+				}
+				platforms = platformReleases(d.Name, osReleases)
+				if !upstream && version.ArtifactoryCache && d.fileCache != nil {
+					// kernels are discovered in release stream, packages
+					// found in the cache are downloaded from it
+					cachedFiles, err = d.useCachedFiles(downloadFileList, version)
+					if err != nil {
+						return nil, err
+					}
+				}
+			case string(FLATCAR):
+				var err error
+				var flatcarReleases map[string][]string
+				downloadFileList, flatcarReleases, err = d.getFlatcarKernelFiles(client, logger, version)
+				if err != nil {
+					return nil, err
+				}
+				platforms = platformReleases(d.Name, flatcarReleases)
 			case string(MINIKUBE):
 				fileList, err := minikubeList(client, version.BaseURL)
 				if err != nil {
@@ -462,56 +798,87 @@ func (d *Distribution) GetKernelList(client *http.Client, logger logger.Logger,
 				if err != nil {
 					return nil, err
 				}
-				downloadFileList, err = getMinikubeKernelFile(downloadFileList, version.KernelURL, version.DefconfigURL, version.KernelDefconfigURL)
+				var minikubeVersions map[string][]string
+				downloadFileList, minikubeVersions, err = d.getMinikubeKernelFiles(client, downloadFileList, version)
 				if err != nil {
 					return nil, err
 				}
+				platforms = platformReleases(string(MINIKUBE), minikubeVersions)
+				if !upstream && version.ArtifactoryCache && d.fileCache != nil {
+					// kernel tarballs are downloaded from the cache, checksums
+					// listed by it are used to verify them
+					cachedFiles, err = d.useCachedFiles(downloadFileList, version)
+					if err != nil {
+						return nil, err
+					}
+				}
 			}
 		}
-		for k, v := range downloadFileList {
-			// build with default config
+		var configFiles []string
+		var configSource string
+		var configFragments []string
+		if d.Name == string(UPSTREAM) {
+			if version.KernelConfig == "" {
+				return nil, fmt.Errorf("kernelConfig is not set for version %s", version.Name)
+			}
+			configFiles, configSource, configFragments = upstreamConfigFiles(version)
+			for k := range downloadFileList {
+				downloadFileList[k] = append(downloadFileList[k], configFiles...)
+			}
+		}
+		// kernels built with default and custom config are set up the same
+		// way, so custom config kernels are verified and named like others
+		newKernel := func(k string, files, fragments []string) *Kernel {
+			kernel := &Kernel{
+				Name:            k,
+				Files:           files,
+				Distro:          Distro(d.Name),
+				DistroVersion:   version.Name,
+				Arch:            version.arch(),
+				ConfigSource:    configSource,
+				ConfigFragments: fragments,
+			}
+			if d.Name == string(MINIKUBE) {
+				kernel.ConfigSource = minikubeConfigFile(k)
+			}
+			kernel.setCachedFiles(cachedFiles)
+			kernel.setRhPackages(rhClient, rhPackageFiles, rhAdvisories)
+			kernel.setUpstreamChecksums(upstreamChecksums)
 			var downloaded bool
 			if upstream {
-				if version.ArtifactoryCache && checkIfKernelInArtifactory(d.Name, version.Name, v, cachedKernels) {
+				// flatcar developer containers are too big to be cached
+				cached := version.ArtifactoryCache && d.Name != string(FLATCAR)
+				if cached && checkIfKernelInArtifactory(d.Name, version.Name, files, kernel.Checksums, cachedKernels) {
 					downloaded = true
-				} else if !version.ArtifactoryCache {
+				} else if !cached {
 					// skip download if cache not enabled for version
 					downloaded = true
 				}
 			}
-			kernel := &Kernel{
-				Name:          k,
-				Files:         v,
-				Distro:        Distro(d.Name),
-				DistroVersion: version.Name,
-				Downloaded:    Status(downloaded),
-			}
-			if mkVersions, ok := minikubeMap[k]; ok {
-				kernel.MinikubeVersions = mkVersions
-			}
-			// Ubuntu reports kernel version with -generic suffix
+			kernel.Downloaded = Status(downloaded)
+			kernel.Platforms = platforms[k]
 			if d.Name == string(UBUNTU) {
-				kernel.LocalVersion = "-generic"
+				kernel.Flavor = version.flavor(d.Name)
 			}
-			kernelList = append(kernelList, kernel)
+			kernel.Name, kernel.LocalVersion = d.kernelRelease(version, k)
+			return kernel
+		}
+		for k, v := range downloadFileList {
+			// build with default config
+			kernelList = append(kernelList, newKernel(k, v, configFragments))
 
 			if version.CustomConfigs != nil {
 				for _, cc := range version.CustomConfigs {
 					if cc.KernelName == k {
+						if cc.Properties == nil {
+							cc.Properties = make(map[string]string)
+						}
 						cc.Properties["CONFIG_LOCALVERSION"] = cc.LocalVersionSuffix
+						ccFiles, ccFragments := configFragmentFiles(cc.Fragments, cc.LocalVersionSuffix)
 						// build with custom config
-						kernel := &Kernel{
-							Name:          k,
-							Files:         v,
-							Distro:        Distro(d.Name),
-							DistroVersion: version.Name,
-							LocalVersion:  cc.LocalVersionSuffix,
-							CustomConfig:  cc.Properties,
-							Downloaded:    Status(downloaded),
-						}
-						if mkVersions, ok := minikubeMap[k]; ok {
-							kernel.MinikubeVersions = mkVersions
-						}
+						kernel := newKernel(k, append(append([]string{}, v...), ccFiles...), append(append([]string{}, configFragments...), ccFragments...))
+						kernel.LocalVersion = cc.LocalVersionSuffix
+						kernel.CustomConfig = cc.Properties
 						kernelList = append(kernelList, kernel)
 					}
 				}
@@ -534,10 +901,77 @@ func (d *Distribution) GetKernelList(client *http.Client, logger logger.Logger,
 			}
 		}
 	}
+	if incompleteErr != nil {
+		return kernelList, incompleteErr
+	}
 	return kernelList, nil
 }
 
-func checkIfKernelInArtifactory(distro, version string, kernelFiles []string, artifactoryKernels artifactory.ArtifactoryKernelCache) bool {
+// setCachedFiles fills checksums and size of kernel files known by cache
+func (k *Kernel) setCachedFiles(cachedFiles map[string]artifactory.CachedFile) {
+	for _, kernelFile := range k.Files {
+		cachedFile, ok := cachedFiles[kernelFile]
+		if !ok {
+			continue
+		}
+		fileName, err := destFileName(kernelFile)
+		if err != nil {
+			continue
+		}
+		if k.Checksums == nil {
+			k.Checksums = make(map[string]string)
+		}
+		k.Checksums[fileName] = cachedFile.Sha256
+		k.Size += cachedFile.Size
+		for _, property := range cachedFile.Advisories {
+			k.addAdvisory(parseAdvisoryProperty(property))
+		}
+	}
+}
+
+// setRhPackages keeps metadata of RedHat packages, advisories which ship them
+// and client used to resolve their download urls
+func (k *Kernel) setRhPackages(rhClient *RhApiClient, rhPackageFiles map[string]RhPackage, rhAdvisories map[string][]Advisory) {
+	for _, kernelFile := range k.Files {
+		rhp, ok := rhPackageFiles[kernelFile]
+		if !ok {
+			continue
+		}
+		k.RhPackages = append(k.RhPackages, rhp)
+		k.rhClient = rhClient
+		if k.Checksums == nil {
+			k.Checksums = make(map[string]string)
+		}
+		k.Checksums[rhp.fileName()] = rhp.Checksum
+		k.setUpstreamChecksums(map[string]string{rhp.fileName(): rhp.Checksum})
+		for _, advisory := range rhAdvisories[rhp.fileName()] {
+			k.addAdvisory(advisory)
+		}
+	}
+}
+
+// setUpstreamChecksums keeps sha256 of kernel files published by upstream,
+// checksums are indexed by file name
+func (k *Kernel) setUpstreamChecksums(checksums map[string]string) {
+	for _, kernelFile := range k.Files {
+		fileName, err := destFileName(kernelFile)
+		if err != nil {
+			continue
+		}
+		checksum := checksums[fileName]
+		if checksum == "" {
+			continue
+		}
+		if k.UpstreamSha256 == nil {
+			k.UpstreamSha256 = make(map[string]string)
+		}
+		k.UpstreamSha256[fileName] = checksum
+	}
+}
+
+// checkIfKernelInArtifactory checks if all kernel files are cached, files with
+// known checksum must have the same checksum in cache
+func checkIfKernelInArtifactory(distro, version string, kernelFiles []string, checksums map[string]string, artifactoryKernels artifactory.ArtifactoryKernelCache) bool {
 	if artifactoryKernels == nil || artifactoryKernels.Empty() {
 		return false
 	}
@@ -555,51 +989,17 @@ func checkIfKernelInArtifactory(distro, version string, kernelFiles []string, ar
 		if !artifactoryKernels.InCache(distro, version, fname) {
 			return false
 		}
-	}
-	return true
-}
-
-func getMinikubeKernelFile(downloadFileList map[string][]string, kernelURL, defconfigURL, kernelDefconfigURL string) (map[string][]string, error) {
-	var newDownloadFileList = make(map[string][]string)
-	var fileMap = make(map[string]struct{})
-	for version := range downloadFileList {
-		minikubeDefconfURL := fmt.Sprintf(defconfigURL, version)
-		response, err := http.Get(minikubeDefconfURL)
-		if err != nil {
-			return nil, err
-		}
-		responseByte, err := io.ReadAll(response.Body)
-		if err != nil {
-			return nil, err
-		}
-		r, err := regexp.Compile(`BR2_LINUX_KERNEL_CUSTOM_VERSION_VALUE="(.*)"`)
-		if err != nil {
-			return nil, err
-		}
-		versionMatch := r.FindStringSubmatch(string(responseByte))
-		if len(versionMatch) > 1 {
-			kernelFileURL := fmt.Sprintf("%s/linux-%s.tar.gz", kernelURL, versionMatch[1])
-
-			minikubeMap[versionMatch[1]] = append(minikubeMap[versionMatch[1]], version)
-			if _, ok := fileMap[filepath.Base(kernelFileURL)]; !ok {
-				newDownloadFileList[versionMatch[1]] = append(newDownloadFileList[versionMatch[1]], kernelFileURL)
-				fileMap[filepath.Base(kernelFileURL)] = struct{}{}
-			}
-			kernelDefconfigString := fmt.Sprintf(kernelDefconfigURL, version)
-			if _, ok := fileMap[versionMatch[1]+filepath.Base(kernelDefconfigString)]; !ok {
-				newDownloadFileList[versionMatch[1]] = append(newDownloadFileList[versionMatch[1]], kernelDefconfigString)
-				fileMap[versionMatch[1]+filepath.Base(kernelDefconfigString)] = struct{}{}
-			}
+		if chksum, ok := checksums[fname]; ok && chksum != "" && !artifactoryKernels.InCacheSumCheck(distro, version, fname, chksum) {
+			return false
 		}
-
 	}
-	return newDownloadFileList, nil
+	return true
 }
 
 func (d *Distribution) parse(fileList []string, version Version) (map[string][]string, error) {
 	var kernelMap = make(map[string][]string)
 	for _, file := range fileList {
-		for _, parser := range d.Parser {
+		for _, parser := range d.parsers(version) {
 			valid, versionMatch, err := validateVersion(file, parser, version.MinVersion, version.MaxVersion)
 			if err != nil {
 				return nil, err
@@ -613,9 +1013,65 @@ func (d *Distribution) parse(fileList []string, version Version) (map[string][]s
 			}
 		}
 	}
+	if d.Name == string(DEBIAN) {
+		addDebianKbuild(kernelMap, fileList, version)
+	}
 	return kernelMap, nil
 }
 
+// KernelName returns name of the kernel to which file belongs and whether it
+// is within version range. Empty name means no parser matches the file.
+func (d *Distribution) KernelName(version Version, fileName string) (string, bool, error) {
+	for _, parser := range d.parsers(version) {
+		valid, versionMatch, err := validateVersion(fileName, parser, version.MinVersion, version.MaxVersion)
+		if err != nil {
+			return "", false, err
+		}
+		switch {
+		case len(versionMatch) == 3:
+			return versionMatch[1] + "." + versionMatch[2], valid, nil
+		case len(versionMatch) > 1:
+			return versionMatch[1], valid, nil
+		}
+	}
+	return "", false, nil
+}
+
+// kernelRelease splits kernel name parsed from file name into name and local
+// version the kernel is reported with
+func (d *Distribution) kernelRelease(version Version, kernelName string) (string, string) {
+	switch Distro(d.Name) {
+	// Ubuntu reports kernel version with flavor suffix
+	case UBUNTU:
+		return kernelName, "-" + version.flavor(d.Name)
+	// Debian reports kernel version with flavour suffix
+	case DEBIAN:
+		return kernelName, "-" + archs[version.arch()].debArch
+	// SUSE reports kernel version with flavour suffix
+	case SLES, OPENSUSE:
+		return kernelName, "-default"
+	case FLATCAR:
+		return kernelName, FLATCAR_LOCAL_VERSION
+	// UEK kernels are reported with uek local version
+	case ORACLE:
+		return oracleKernelName(kernelName)
+	}
+	return kernelName, ""
+}
+
+// IsRequired checks if kernel parsed from file name of version is listed in
+// requiredVersions, kernel is reported with local version the same way as
+// discovered kernels
+func (d *Distribution) IsRequired(version Version, kernelName string) bool {
+	name, localVersion := d.kernelRelease(version, kernelName)
+	for _, rv := range d.RequiredVersions {
+		if rv == name+localVersion {
+			return true
+		}
+	}
+	return false
+}
+
 func validateVersion(file, parser, minVerStr, maxVerStr string) (bool, []string, error) {
 	r, err := regexp.Compile(parser)
 	if err != nil {
@@ -647,28 +1103,6 @@ func validateVersion(file, parser, minVerStr, maxVerStr string) (bool, []string,
 	return valid, versionMatch, nil
 }
 
-func getMinikubeTags(client *http.Client, githubOwner, repo string) ([]string, error) {
-	gclient := github.NewClient(client)
-	opt := &github.ListOptions{PerPage: 99}
-	var allTags []*github.RepositoryTag
-	for {
-		tags, resp, err := gclient.Repositories.ListTags(context.Background(), githubOwner, repo, opt)
-		if err != nil {
-			return nil, err
-		}
-		allTags = append(allTags, tags...)
-		if resp.NextPage == 0 {
-			break
-		}
-		opt.Page = resp.NextPage
-	}
-	var tags []string
-	for _, tag := range allTags {
-		tags = append(tags, *tag.Name)
-	}
-	return tags, nil
-}
-
 func getHttpStringRespone(client *http.Client, url string) (string, error) {
 	response, err := client.Get(url)
 	if err != nil {
@@ -747,75 +1181,3 @@ func extractTGZ(logger logger.Logger, dst, filename string) error {
 		}
 	}
 }
-
-func prepareCustomConfig(logger logger.Logger, configFilePath string, configuration map[string]string) error {
-	input, err := ioutil.ReadFile(configFilePath)
-	if err != nil {
-		return err
-	}
-	defaultConfig := make(map[string]string)
-	lines := strings.Split(string(input), "\n")
-	r, err := regexp.Compile(`^#\s+(.*)\s+is not set`)
-	if err != nil {
-		return err
-	}
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		switch {
-		case len(line) == 0:
-			continue
-		case strings.HasPrefix(line, "#"):
-			match := r.FindStringSubmatch(line)
-			if len(match) > 1 {
-				defaultConfig[match[1]] = "n"
-			}
-			continue
-		}
-		splits := strings.Split(line, "=")
-		if len(splits) < 2 {
-			logger.Errorf("unable to parse kernel config line: %s", line)
-			continue
-		}
-		defaultConfig[splits[0]] = splits[1]
-	}
-
-	for k, v := range configuration {
-		defaultConfig[k] = v
-	}
-
-	f, err := os.Create(configFilePath)
-
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	w := bufio.NewWriter(f)
-
-	for key, value := range defaultConfig {
-		w.WriteString(key + "=" + escapeStringValue(value) + "\n")
-	}
-
-	return w.Flush()
-}
-
-func escapeStringValue(value string) string {
-	switch value {
-	case "y", "n", "m", "Y", "N", "M":
-		return value
-	}
-	if strings.HasPrefix(value, "-") {
-		if _, err := strconv.ParseInt(value, 0, 64); err == nil {
-			return value
-		}
-	} else {
-		if _, err := strconv.ParseUint(value, 0, 64); err == nil {
-			return value
-		}
-	}
-
-	if len(value) > 0 && value[0] == '"' && value[len(value)-1] == '"' {
-		return value
-	}
-	return "\"" + value + "\""
-}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package distribution
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes exclusive lock shared between processes, returned function
+// releases it
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package distribution
+
+// lockFile is a no-op, kernel downloader runs on linux
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
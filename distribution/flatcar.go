@@ -0,0 +1,163 @@
+package distribution
+
+import (
+	"compress/bzip2"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/diskimage"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/logger"
+)
+
+const (
+	FLATCAR_DEV_CONTAINER = "flatcar_developer_container.bin.bz2"
+	FLATCAR_LOCAL_VERSION = "-flatcar"
+	// FLATCAR_BUILD_DIR is kernel build directory in developer container, %s
+	// is replaced with kernel release
+	FLATCAR_BUILD_DIR = "/usr/lib/modules/%s/build"
+)
+
+// flatcarRelease is a release of Flatcar release feed
+// (https://www.flatcar.org/releases-json/releases-<channel>.json)
+type flatcarRelease struct {
+	MajorSoftware struct {
+		Kernel []string `json:"kernel"`
+	} `json:"major_software"`
+}
+
+// getFlatcarKernelFiles reads kernel of every Flatcar release in release feed
+// and returns developer container of the newest release shipping the kernel
+// together with Flatcar releases shipping every kernel
+func (d *Distribution) getFlatcarKernelFiles(client *http.Client, logger logger.Logger, version Version) (map[string][]string, map[string][]string, error) {
+	var feed map[string]json.RawMessage
+	if err := readJSON(client, version.ReleaseStream, &feed); err != nil {
+		return nil, nil, err
+	}
+	var releases []*semver.Version
+	for name := range feed {
+		release, err := semver.NewVersion(name)
+		if err != nil {
+			// feed contains also "current" alias
+			continue
+		}
+		releases = append(releases, release)
+	}
+	sort.Sort(sort.Reverse(semver.Collection(releases)))
+	kernelMap := make(map[string][]string)
+	flatcarReleases := make(map[string][]string)
+	for i, release := range releases {
+		if version.MaxReleases > 0 && i >= version.MaxReleases {
+			break
+		}
+		var r flatcarRelease
+		if err := json.Unmarshal(feed[release.Original()], &r); err != nil {
+			return nil, nil, fmt.Errorf("unable to read flatcar release %s: %v", release.Original(), err)
+		}
+		if len(r.MajorSoftware.Kernel) < 1 {
+			logger.Errorf("kernel not found in %s release %s", d.Name, release.Original())
+			continue
+		}
+		kernelName := r.MajorSoftware.Kernel[0]
+		valid, _, err := validateVersion(kernelName, `^(.+)$`, version.MinVersion, version.MaxVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !valid {
+			continue
+		}
+		if _, ok := kernelMap[kernelName]; !ok {
+			// developer containers of all releases are named the same
+			fileName := fmt.Sprintf("flatcar_developer_container-%s.bin.bz2", release.Original())
+			kernelMap[kernelName] = []string{fmt.Sprintf("%s/%s/%s#%s", strings.TrimSuffix(version.BaseURL, "/"), release.Original(), FLATCAR_DEV_CONTAINER, fileName)}
+		}
+		flatcarReleases[kernelName] = append(flatcarReleases[kernelName], release.Original())
+	}
+	return kernelMap, flatcarReleases, nil
+}
+
+// extractFlatcar downloads developer container image and copies kernel build
+// directory from it to kernelDir. Image is read without loop mount, so it
+// works in unprivileged containers. When ContextDir is set build directory is
+// copied to the build context instead and added to the image by generated
+// Dockerfile.
+func (k *Kernel) extractFlatcar(client *http.Client, logger logger.Logger, kernelDir string) error {
+	if len(k.Files) < 1 {
+		return fmt.Errorf("developer container not found for kernel %s", k.Name)
+	}
+	fileName, err := destFileName(k.Files[0])
+	if err != nil {
+		return err
+	}
+	compressed := filepath.Join(kernelDir, fileName)
+	start := time.Now()
+	err = downloadFile(client, logger, compressed, k.Files[0])
+	k.addDuration(PHASE_DOWNLOAD, start)
+	if err != nil {
+		return err
+	}
+	k.Downloaded = SUCCESS
+	defer k.addDuration(PHASE_EXTRACT, time.Now())
+	image := strings.TrimSuffix(compressed, ".bz2")
+	logger.Infof("decompressing %s", compressed)
+	if err := decompressBzip2(compressed, image); err != nil {
+		return err
+	}
+	defer os.Remove(image)
+	if err := os.Remove(compressed); err != nil {
+		return err
+	}
+	f, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fs, err := diskimage.OpenImage(f)
+	if err != nil {
+		return err
+	}
+	buildDir := filepath.Join(kernelDir, "build")
+	extractDir := buildDir
+	if k.ContextDir != "" {
+		contextPath := filepath.Join(k.DirName(), "build")
+		extractDir = filepath.Join(k.ContextDir, contextPath)
+		k.ContextFiles = map[string]string{contextPath: buildDir}
+	}
+	if err := os.RemoveAll(extractDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(extractDir), 0755); err != nil {
+		return err
+	}
+	logger.Infof("extracting kernel %s build directory from %s to %s", k.Name+k.LocalVersion, image, extractDir)
+	if err := fs.Extract(fmt.Sprintf(FLATCAR_BUILD_DIR, k.Name+k.LocalVersion), extractDir); err != nil {
+		return err
+	}
+	k.KernelPath = buildDir
+	return nil
+}
+
+func decompressBzip2(src, dest string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	destFile, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+	if _, err := io.Copy(destFile, bzip2.NewReader(srcFile)); err != nil {
+		return fmt.Errorf("unable to decompress %s: %v", src, err)
+	}
+	return nil
+}
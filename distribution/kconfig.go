@@ -0,0 +1,230 @@
+package distribution
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	kconfigSet    = regexp.MustCompile(`^(CONFIG_\w+)=(.*)$`)
+	kconfigNotSet = regexp.MustCompile(`^#\s*(CONFIG_\w+) is not set$`)
+)
+
+// kconfig is a kernel config which keeps lines in their original order,
+// assignments can be replaced in place and new ones are appended
+type kconfig struct {
+	lines   []string
+	symbols map[string]int
+}
+
+func newKconfig() *kconfig {
+	return &kconfig{symbols: make(map[string]int)}
+}
+
+// kconfigLine parses assignment, "# CONFIG_X is not set" is returned with n
+// value. ok is false for comments and empty lines.
+func kconfigLine(line string) (symbol, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if match := kconfigNotSet.FindStringSubmatch(line); match != nil {
+		return match[1], "n", true
+	}
+	if match := kconfigSet.FindStringSubmatch(line); match != nil {
+		return match[1], match[2], true
+	}
+	return "", "", false
+}
+
+// formatKconfig returns config line for symbol, n value is written as
+// "is not set" comment as done by kconfig
+func formatKconfig(symbol, value string) string {
+	if value == "n" || value == "N" {
+		return fmt.Sprintf("# %s is not set", symbol)
+	}
+	return symbol + "=" + escapeStringValue(value)
+}
+
+// readKconfig reads kernel config file, gzip compressed configs (dump of
+// /proc/config.gz) are decompressed
+func readKconfig(configFile string) (*kconfig, error) {
+	f, err := os.Open(configFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read kernel config %s: %v", configFile, err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	c := newKconfig()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		c.addLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read kernel config %s: %v", configFile, err)
+	}
+	return c, nil
+}
+
+func (c *kconfig) addLine(line string) {
+	symbol, value, ok := kconfigLine(line)
+	if !ok {
+		c.lines = append(c.lines, line)
+		return
+	}
+	c.set(symbol, value)
+}
+
+// set replaces assignment of symbol or appends it at the end of config
+func (c *kconfig) set(symbol, value string) {
+	line := formatKconfig(symbol, value)
+	if i, ok := c.symbols[symbol]; ok {
+		c.lines[i] = line
+		return
+	}
+	c.symbols[symbol] = len(c.lines)
+	c.lines = append(c.lines, line)
+}
+
+// merge applies assignments of other config, comments of fragment are kept
+// before its assignments appended to config
+func (c *kconfig) merge(other *kconfig) {
+	for _, line := range other.lines {
+		symbol, value, ok := kconfigLine(line)
+		if !ok {
+			c.lines = append(c.lines, line)
+			continue
+		}
+		c.set(symbol, value)
+	}
+}
+
+// values returns assignments of config, symbols which are not set have n
+// value
+func (c *kconfig) values() map[string]string {
+	values := make(map[string]string)
+	for symbol, i := range c.symbols {
+		_, value, _ := kconfigLine(c.lines[i])
+		values[symbol] = value
+	}
+	return values
+}
+
+func (c *kconfig) write(configFile string) error {
+	f, err := os.Create(configFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, line := range c.lines {
+		if _, err := w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// verifyKconfig checks that every requested option survived olddefconfig,
+// options which dependencies are not met are silently dropped by kconfig
+func verifyKconfig(configFile string, requested map[string]string) error {
+	c, err := readKconfig(configFile)
+	if err != nil {
+		return err
+	}
+	actual := c.values()
+	var symbols []string
+	for symbol := range requested {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	var diff []string
+	for _, symbol := range symbols {
+		want := formatKconfig(symbol, requested[symbol])
+		value, ok := actual[symbol]
+		if !ok {
+			// symbols which are not visible are not written at all
+			value = "n"
+		}
+		got := formatKconfig(symbol, value)
+		if got != want {
+			diff = append(diff, "-"+want, "+"+got)
+		}
+	}
+	if len(diff) > 0 {
+		return fmt.Errorf("kernel config options requested for the kernel were changed by olddefconfig, check their dependencies:\n%s", strings.Join(diff, "\n"))
+	}
+	return nil
+}
+
+// prepareConfig writes .config of kernel sources from kernel config, config
+// fragments and custom config properties applied in this order. Options set by
+// fragments and custom config are returned to be verified after olddefconfig.
+func (k *Kernel) prepareConfig() (map[string]string, error) {
+	kernelDir := filepath.Dir(k.KernelPath)
+	configFile := k.ConfigSource
+	if configFile == "" {
+		configFile = KERNEL_CONFIG_FILE
+	}
+	if !filepath.IsAbs(configFile) {
+		configFile = filepath.Join(kernelDir, configFile)
+	}
+	config, err := readKconfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	requested := make(map[string]string)
+	for _, fragmentFile := range k.ConfigFragments {
+		if !filepath.IsAbs(fragmentFile) {
+			fragmentFile = filepath.Join(kernelDir, fragmentFile)
+		}
+		fragment, err := readKconfig(fragmentFile)
+		if err != nil {
+			return nil, err
+		}
+		config.merge(fragment)
+		for symbol, value := range fragment.values() {
+			requested[symbol] = value
+		}
+	}
+	for symbol, value := range k.CustomConfig {
+		config.set(symbol, value)
+		requested[symbol] = value
+	}
+	return requested, config.write(filepath.Join(k.KernelPath, ".config"))
+}
+
+func escapeStringValue(value string) string {
+	switch value {
+	case "y", "n", "m", "Y", "N", "M":
+		return value
+	}
+	if strings.HasPrefix(value, "-") {
+		if _, err := strconv.ParseInt(value, 0, 64); err == nil {
+			return value
+		}
+	} else {
+		if _, err := strconv.ParseUint(value, 0, 64); err == nil {
+			return value
+		}
+	}
+
+	if len(value) > 0 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value
+	}
+	return "\"" + value + "\""
+}
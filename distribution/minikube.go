@@ -0,0 +1,204 @@
+package distribution
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// MinikubeLayout points to buildroot defconfigs of minikube ISO, %s is
+// replaced with minikube version. ISO layout changed between minikube releases
+// (board/coreos/minikube, board/minikube/<arch>) so several layouts can be
+// tried for every tag.
+type MinikubeLayout struct {
+	DefconfigURL       string `yaml:"defconfigURL"`
+	KernelDefconfigURL string `yaml:"kernelDefconfigURL"`
+}
+
+// minikubeKernel is a kernel shipped in minikube ISO
+type minikubeKernel struct {
+	version            string
+	kernelDefconfigURL string
+}
+
+// minikubeResolver caches kernels found for minikube tags and layouts, tags
+// are immutable so every defconfig is fetched once
+type minikubeResolver struct {
+	client  *http.Client
+	kernels map[string]*minikubeKernel
+}
+
+var minikubeKernelVersion = regexp.MustCompile(`BR2_LINUX_KERNEL_CUSTOM_VERSION_VALUE="(.*)"`)
+
+func newMinikubeResolver(client *http.Client) *minikubeResolver {
+	return &minikubeResolver{client: client, kernels: make(map[string]*minikubeKernel)}
+}
+
+func (v Version) minikubeLayouts() []MinikubeLayout {
+	var layouts []MinikubeLayout
+	if v.DefconfigURL != "" {
+		layouts = append(layouts, MinikubeLayout{DefconfigURL: v.DefconfigURL, KernelDefconfigURL: v.KernelDefconfigURL})
+	}
+	return append(layouts, v.MinikubeLayouts...)
+}
+
+// kernel returns kernel shipped with minikube tag or nil when none of layouts
+// matches the tag
+func (r *minikubeResolver) kernel(tag string, layouts []MinikubeLayout) (*minikubeKernel, error) {
+	// versions can list different layouts for the same tag
+	key := fmt.Sprintf("%s %v", tag, layouts)
+	if k, ok := r.kernels[key]; ok {
+		return k, nil
+	}
+	for _, layout := range layouts {
+		defconfig, found, err := r.fetch(fmt.Sprintf(layout.DefconfigURL, tag))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		versionMatch := minikubeKernelVersion.FindStringSubmatch(defconfig)
+		if len(versionMatch) < 2 {
+			continue
+		}
+		k := &minikubeKernel{
+			version:            versionMatch[1],
+			kernelDefconfigURL: fmt.Sprintf(layout.KernelDefconfigURL, tag),
+		}
+		r.kernels[key] = k
+		return k, nil
+	}
+	r.kernels[key] = nil
+	return nil, nil
+}
+
+func (r *minikubeResolver) fetch(fileURL string) (string, bool, error) {
+	response, err := r.client.Get(fileURL)
+	if err != nil {
+		return "", false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("unable to fetch %s: %s", fileURL, response.Status)
+	}
+	responseByte, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", false, err
+	}
+	return string(responseByte), true, nil
+}
+
+// getMinikubeKernelFiles maps minikube versions to kernels. It returns files of
+// every kernel and minikube versions which ship the kernel.
+func (d *Distribution) getMinikubeKernelFiles(client *http.Client, minikubeVersions map[string][]string, version Version) (map[string][]string, map[string][]string, error) {
+	if d.minikube == nil {
+		d.minikube = newMinikubeResolver(client)
+	}
+	var tags []string
+	for tag := range minikubeVersions {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	kernelFiles := make(map[string][]string)
+	kernelTags := make(map[string][]string)
+	for _, tag := range tags {
+		k, err := d.minikube.kernel(tag, version.minikubeLayouts())
+		if err != nil {
+			return nil, nil, err
+		}
+		if k == nil {
+			continue
+		}
+		kernelTags[k.version] = append(kernelTags[k.version], tag)
+		if _, ok := kernelFiles[k.version]; ok {
+			// defconfig of the oldest minikube version shipping the kernel is used
+			continue
+		}
+		kernelFileURL, err := minikubeKernelURL(version.KernelURL, k.version)
+		if err != nil {
+			return nil, nil, err
+		}
+		// defconfigs of all kernels are cached in directory of the version,
+		// so every one is stored under kernel specific name
+		defconfigURL := k.kernelDefconfigURL + "#" + minikubeConfigFile(k.version)
+		kernelFiles[k.version] = []string{kernelFileURL, defconfigURL}
+	}
+	return kernelFiles, kernelTags, nil
+}
+
+// minikubeKernelURL returns url of kernel tarball, %d in kernelURL is replaced
+// with kernel major version (e.g. https://cdn.kernel.org/pub/linux/kernel/v%d.x)
+func minikubeKernelURL(kernelURL, kernelVersion string) (string, error) {
+	if strings.Contains(kernelURL, "%d") {
+		var major int
+		if _, err := fmt.Sscanf(kernelVersion, "%d.", &major); err != nil {
+			return "", fmt.Errorf("unable to parse kernel version %s: %v", kernelVersion, err)
+		}
+		kernelURL = fmt.Sprintf(kernelURL, major)
+	}
+	return fmt.Sprintf("%s/linux-%s.tar.gz", kernelURL, kernelVersion), nil
+}
+
+func minikubeList(client *http.Client, baseURL string) ([]string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	var fileList []string
+	if u.Host == "github.com" {
+		p := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(p) < 2 {
+			return nil, fmt.Errorf("unable to find owner and repository in provided url: %s", baseURL)
+		}
+		fileList, err = getMinikubeTags(client, p[0], p[1])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		fileList, err = hrefList(client, baseURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fileList, nil
+
+}
+
+func getMinikubeTags(client *http.Client, githubOwner, repo string) ([]string, error) {
+	gclient := github.NewClient(client)
+	opt := &github.ListOptions{PerPage: 99}
+	var allTags []*github.RepositoryTag
+	for {
+		tags, resp, err := gclient.Repositories.ListTags(context.Background(), githubOwner, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		allTags = append(allTags, tags...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	var tags []string
+	for _, tag := range allTags {
+		tags = append(tags, *tag.Name)
+	}
+	return tags, nil
+}
+
+// minikubeConfigFile is the name under which kernel defconfig is stored next
+// to kernel sources
+func minikubeConfigFile(kernelVersion string) string {
+	return fmt.Sprintf("linux-%s_defconfig", kernelVersion)
+}
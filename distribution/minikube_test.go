@@ -0,0 +1,70 @@
+package distribution
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/artifactory"
+)
+
+func TestMinikubeResolverLayouts(t *testing.T) {
+	defconfigs := map[string]string{
+		"/old/v1.25.2/minikube_defconfig": `BR2_LINUX_KERNEL_CUSTOM_VERSION_VALUE="4.19.202"`,
+		"/new/v1.25.2/minikube_defconfig": `BR2_LINUX_KERNEL_CUSTOM_VERSION_VALUE="5.10.57"`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defconfig, ok := defconfigs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(defconfig))
+	}))
+	defer server.Close()
+	layout := func(dir string) []MinikubeLayout {
+		return []MinikubeLayout{{
+			DefconfigURL:       server.URL + "/" + dir + "/%s/minikube_defconfig",
+			KernelDefconfigURL: server.URL + "/" + dir + "/%s/linux_x86_64_defconfig",
+		}}
+	}
+	resolver := newMinikubeResolver(server.Client())
+	for dir, want := range map[string]string{"old": "4.19.202", "new": "5.10.57", "missing": ""} {
+		k, err := resolver.kernel("v1.25.2", layout(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if k != nil {
+			got = k.version
+		}
+		if got != want {
+			t.Errorf("layout %s: got kernel %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestReplaceCachedFiles(t *testing.T) {
+	kernelMap := map[string][]string{
+		"4.19.202": {
+			"https://cdn.kernel.org/pub/linux/kernel/v4.x/linux-4.19.202.tar.gz",
+			"https://raw.githubusercontent.com/kubernetes/minikube/v1.25.2/linux_x86_64_defconfig#linux-4.19.202_defconfig",
+		},
+	}
+	files := map[string]artifactory.CachedFile{
+		"linux-4.19.202.tar.gz":    {URL: "http://cache/minikube/all/linux-4.19.202.tar.gz", Sha256: "aaa"},
+		"linux-4.19.202_defconfig": {URL: "http://cache/minikube/all/linux-4.19.202_defconfig", Sha256: "bbb"},
+		"linux-5.10.57_defconfig":  {URL: "http://cache/minikube/all/linux-5.10.57_defconfig", Sha256: "ccc"},
+		"linux_x86_64_defconfig":   {URL: "http://cache/minikube/all/linux_x86_64_defconfig", Sha256: "ddd"},
+	}
+	cached := replaceCachedFiles(kernelMap, files)
+	want := []string{"http://cache/minikube/all/linux-4.19.202.tar.gz", "http://cache/minikube/all/linux-4.19.202_defconfig"}
+	for i, fileURL := range kernelMap["4.19.202"] {
+		if fileURL != want[i] {
+			t.Errorf("got file %s, want %s", fileURL, want[i])
+		}
+	}
+	if len(cached) != 2 {
+		t.Errorf("got cached files %v", cached)
+	}
+}
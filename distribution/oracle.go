@@ -0,0 +1,40 @@
+package distribution
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Unbreakable Enterprise Kernel release has uek suffix after dist tag, e.g.
+// 5.15.0-100.96.32.el8uek.x86_64, arch is part of the release only when it is
+// captured by parser
+var oracleUEKRelease = regexp.MustCompile(`^(.+\.el\w+?)(uek(?:\..+)?)$`)
+
+// oracleKernelName splits UEK kernel release into name and uek local version
+// so UEK kernels are distinguished from Red Hat compatible kernels in reports,
+// RHCK kernels are returned unchanged
+func oracleKernelName(release string) (string, string) {
+	match := oracleUEKRelease.FindStringSubmatch(release)
+	if len(match) < 3 {
+		return release, ""
+	}
+	return match[1], match[2]
+}
+
+// indexURL returns page listing packages of version, some repositories
+// (yum.oracle.com) list packages on index page with links relative to it
+func (v Version) indexURL() string {
+	if v.IndexURL != "" {
+		return v.IndexURL
+	}
+	return v.BaseURL
+}
+
+// validateIndexURL rejects index page together with vault, discovery with
+// vault lists BaseURL and VaultURL directly so index page would be ignored
+func (v Version) validateIndexURL() error {
+	if v.IndexURL != "" && v.VaultURL != "" {
+		return fmt.Errorf("indexURL and vaultURL can't be used together in version %s", v.Name)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package distribution
+
+import "testing"
+
+func TestOracleKernelName(t *testing.T) {
+	tests := []struct {
+		release      string
+		name         string
+		localVersion string
+	}{
+		{release: "5.15.0-100.96.32.el8uek.x86_64", name: "5.15.0-100.96.32.el8", localVersion: "uek.x86_64"},
+		{release: "5.15.0-100.96.32.el8uek", name: "5.15.0-100.96.32.el8", localVersion: "uek"},
+		{release: "5.4.17-2136.307.3.1.el8_7uek.x86_64", name: "5.4.17-2136.307.3.1.el8_7", localVersion: "uek.x86_64"},
+		{release: "4.18.0-425.3.1.el8.x86_64", name: "4.18.0-425.3.1.el8.x86_64"},
+		{release: "4.18.0-425.3.1.el8", name: "4.18.0-425.3.1.el8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.release, func(t *testing.T) {
+			name, localVersion := oracleKernelName(tt.release)
+			if name != tt.name || localVersion != tt.localVersion {
+				t.Errorf("got %s + %s, want %s + %s", name, localVersion, tt.name, tt.localVersion)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		version Version
+		valid   bool
+	}{
+		{name: "index page", version: Version{Name: "8", IndexURL: "https://yum.oracle.com/index.html", BaseURL: "https://yum.oracle.com/getPackage"}, valid: true},
+		{name: "vault", version: Version{Name: "8.6", BaseURL: "https://dl.rockylinux.org/pub", VaultURL: "https://dl.rockylinux.org/vault"}, valid: true},
+		{name: "index page with vault", version: Version{Name: "8", IndexURL: "https://yum.oracle.com/index.html", VaultURL: "https://yum.oracle.com/vault"}},
+		{name: "unsupported arch", version: Version{Name: "8", Arch: "ppc64le"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distributions := Distributions{Distributions: []Distribution{{Name: "oraclelinux", Versions: []Version{tt.version}}}}
+			if err := distributions.Validate(); (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
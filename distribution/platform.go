@@ -0,0 +1,34 @@
+package distribution
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// PlatformRelease is a release of platform (minikube ISO, CoreOS build) which
+// ships the kernel
+type PlatformRelease struct {
+	Platform string
+	Release  string
+}
+
+// platformReleases maps kernels to releases of platform shipping them
+func platformReleases(platform string, kernelReleases map[string][]string) map[string][]PlatformRelease {
+	platforms := make(map[string][]PlatformRelease)
+	for kernelName, releases := range kernelReleases {
+		for _, release := range releases {
+			platforms[kernelName] = append(platforms[kernelName], PlatformRelease{Platform: platform, Release: release})
+		}
+	}
+	return platforms
+}
+
+// ModulePath returns path of vrouter module compiled for the kernel
+func (k *Kernel) ModulePath() string {
+	return fmt.Sprintf("/kernelmodules/%s/%s/vrouter.ko", k.Name+k.LocalVersion, k.arch())
+}
+
+// LogPath returns path of build log written next to the module
+func (k *Kernel) LogPath() string {
+	return filepath.Join(filepath.Dir(k.ModulePath()), "build.log")
+}
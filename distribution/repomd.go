@@ -0,0 +1,184 @@
+package distribution
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/logger"
+)
+
+// repomd is repodata/repomd.xml of yum repository pointing to metadata files
+type repomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+// repomdPrimary is primary metadata listing packages of yum repository
+type repomdPrimary struct {
+	Packages []struct {
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+		Checksum struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"checksum"`
+	} `xml:"package"`
+}
+
+// repomdFileList lists packages of yum repository at baseURL from its
+// metadata. Returned file names are matched with parser, locations map file
+// names to package paths relative to baseURL and checksums map file names to
+// sha256 published in metadata.
+func repomdFileList(client *http.Client, baseURL string) ([]string, map[string]string, map[string]string, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var md repomd
+	if err := getXML(client, baseURL+"/repodata/repomd.xml", &md); err != nil {
+		return nil, nil, nil, err
+	}
+	primaryHref := ""
+	for _, data := range md.Data {
+		if data.Type == "primary" {
+			primaryHref = data.Location.Href
+			break
+		}
+	}
+	if primaryHref == "" {
+		return nil, nil, nil, fmt.Errorf("primary metadata not found in %s/repodata/repomd.xml", baseURL)
+	}
+	var primary repomdPrimary
+	if err := getXML(client, baseURL+"/"+primaryHref, &primary); err != nil {
+		return nil, nil, nil, err
+	}
+	var fileList []string
+	locations := make(map[string]string)
+	checksums := make(map[string]string)
+	for _, p := range primary.Packages {
+		fileName := path.Base(p.Location.Href)
+		fileList = append(fileList, fileName)
+		locations[fileName] = p.Location.Href
+		if p.Checksum.Type == "sha256" {
+			checksums[fileName] = strings.TrimSpace(p.Checksum.Value)
+		}
+	}
+	return fileList, locations, checksums, nil
+}
+
+// repomdKernelFiles discovers kernels of version in yum repository metadata.
+// Repository is at BaseURL or at the first available mirror from
+// MirrorListURL. Sha256 of packages published in metadata is returned by file
+// name.
+func (d *Distribution) repomdKernelFiles(client *http.Client, logger logger.Logger, version Version) (map[string][]string, map[string]string, error) {
+	if version.MirrorListURL == "" {
+		return d.repomdRepoKernelFiles(client, version)
+	}
+	mirrors, err := mirrorList(client, version.MirrorListURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, mirror := range mirrors {
+		mirrorVersion := version
+		mirrorVersion.BaseURL = mirror
+		kernelMap, checksums, err := d.repomdRepoKernelFiles(client, mirrorVersion)
+		if err != nil {
+			logger.Errorf("%s version %s mirror %s: %v", d.Name, version.Name, mirror, err)
+			continue
+		}
+		return kernelMap, checksums, nil
+	}
+	return nil, nil, fmt.Errorf("no mirror of %s version %s available in %s", d.Name, version.Name, version.MirrorListURL)
+}
+
+func (d *Distribution) repomdRepoKernelFiles(client *http.Client, version Version) (map[string][]string, map[string]string, error) {
+	fileList, locations, checksums, err := repomdFileList(client, version.BaseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	kernelMap, err := d.parse(fileList, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	relocate(kernelMap, version.BaseURL, locations)
+	return kernelMap, checksums, nil
+}
+
+// mirrorList returns repository urls listed in yum mirror list, one per line
+func mirrorList(client *http.Client, mirrorListURL string) ([]string, error) {
+	response, err := client.Get(mirrorListURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s for %s", response.Status, mirrorListURL)
+	}
+	var mirrors []string
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		mirrors = append(mirrors, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(mirrors) == 0 {
+		return nil, fmt.Errorf("mirror list %s is empty", mirrorListURL)
+	}
+	return mirrors, nil
+}
+
+// relocate replaces urls built by parser with package locations from
+// repository metadata, packages are often stored in subdirectories
+func relocate(kernelMap map[string][]string, baseURL string, locations map[string]string) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	for kernelName, files := range kernelMap {
+		for i, file := range files {
+			if location, ok := locations[path.Base(file)]; ok {
+				kernelMap[kernelName][i] = baseURL + "/" + location
+			}
+		}
+	}
+}
+
+// getXML decodes xml document at url, gzip compressed documents are
+// decompressed
+func getXML(client *http.Client, url string, v interface{}) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s for %s", response.Status, url)
+	}
+	var r io.Reader = response.Body
+	switch {
+	case strings.HasSuffix(url, ".gz"):
+		gzr, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %v", url, err)
+		}
+		defer gzr.Close()
+		r = gzr
+	case strings.HasSuffix(url, ".xml"):
+	default:
+		return fmt.Errorf("unsupported compression of %s", url)
+	}
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode %s: %v", url, err)
+	}
+	return nil
+}
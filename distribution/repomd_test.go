@@ -0,0 +1,80 @@
+package distribution
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// repomdServer serves yum repository metadata of SUSE kernel packages
+func repomdServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repo/repodata/repomd.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<repomd><data type="filelists"><location href="repodata/filelists.xml"/></data><data type="primary"><location href="repodata/primary.xml"/></data></repomd>`))
+	})
+	mux.HandleFunc("/repo/repodata/primary.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<metadata>
+<package><checksum type="sha256" pkgid="YES">
+abc123</checksum><location href="x86_64/kernel-default-devel-5.14.21-150500.55.19.1.x86_64.rpm"/></package>
+<package><checksum type="sha1" pkgid="YES">def456</checksum><location href="noarch/kernel-devel-5.14.21-150500.55.19.1.noarch.rpm"/></package>
+</metadata>`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRepomdFileList(t *testing.T) {
+	server := repomdServer()
+	defer server.Close()
+	fileList, locations, checksums, err := repomdFileList(server.Client(), server.URL+"/repo/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileList) != 2 {
+		t.Errorf("got files %v", fileList)
+	}
+	if got := locations["kernel-devel-5.14.21-150500.55.19.1.noarch.rpm"]; got != "noarch/kernel-devel-5.14.21-150500.55.19.1.noarch.rpm" {
+		t.Errorf("got location %s", got)
+	}
+	// only sha256 is published as upstream checksum
+	want := map[string]string{"kernel-default-devel-5.14.21-150500.55.19.1.x86_64.rpm": "abc123"}
+	if len(checksums) != len(want) || checksums["kernel-default-devel-5.14.21-150500.55.19.1.x86_64.rpm"] != "abc123" {
+		t.Errorf("got checksums %v, want %v", checksums, want)
+	}
+}
+
+func TestGetKernelListCustomConfig(t *testing.T) {
+	server := repomdServer()
+	defer server.Close()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	d := Distribution{
+		Name:   string(SLES),
+		Parser: []string{`kernel-default-devel-(.+)\.\d+\.x86_64\.rpm`, `kernel-devel-(.+)\.\d+\.noarch\.rpm`},
+		Versions: []Version{{
+			Name:          "15.5",
+			MinVersion:    "5.14.21-150500.55.0",
+			MaxVersion:    "5.14.21-150500.999",
+			BaseURL:       server.URL + "/repo/",
+			CustomConfigs: []CustomConfig{{KernelName: "5.14.21-150500.55.19", LocalVersionSuffix: "-debug"}},
+		}},
+	}
+	kernels, err := d.GetKernelList(context.Background(), server.Client(), logger, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kernels) != 2 {
+		t.Fatalf("got kernels %v", kernels)
+	}
+	// custom config kernel is set up like the default one
+	base, custom := kernels[0], kernels[1]
+	if base.LocalVersion != "-default" || custom.Name != base.Name || custom.LocalVersion != "-debug" {
+		t.Errorf("got kernels %s%s and %s%s", base.Name, base.LocalVersion, custom.Name, custom.LocalVersion)
+	}
+	if got := custom.UpstreamSha256["kernel-default-devel-5.14.21-150500.55.19.1.x86_64.rpm"]; got != "abc123" {
+		t.Errorf("got upstream checksums %v", custom.UpstreamSha256)
+	}
+}
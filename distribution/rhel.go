@@ -4,32 +4,34 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"net/http"
 	"regexp"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"golang.org/x/oauth2"
-	"golang.org/x/sync/errgroup"
-	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/artifactory"
 	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/logger"
 )
 
-var (
-	config = oauth2.Config{
-		ClientID: "rhsm-api",
-		Scopes:   []string{"refresh_token"},
-		Endpoint: oauth2.Endpoint{
-			TokenURL: "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"}}
+const (
+	RH_API_URL       = "https://api.access.redhat.com/management/v1"
+	RH_API_PAGE_SIZE = 100
+	RH_API_RETRIES   = 5
+	// signed url is refreshed when it expires in less than margin
+	RH_URL_EXPIRY_MARGIN = 5 * time.Minute
 )
 
 const (
-	RH_API_THREADS = 3
+	RH_DISCOVERY_PACKAGES = "packages"
+	RH_DISCOVERY_ERRATA   = "errata"
 )
 
+// matches synopsis like "Important: kernel security, bug fix, and enhancement update"
+var rhKernelSynopsis = regexp.MustCompile(`(^|:\s*)kernel\s`)
+
 type RepoContent struct {
 	Pagination Pagination  `json:"pagination"`
 	Packages   []RhPackage `json:"body"`
@@ -50,6 +52,24 @@ type RhPackage struct {
 	Href         string   `json:"href"`
 	DownloadHref string   `json:"downloadHref"`
 }
+type ErrataContent struct {
+	Pagination Pagination `json:"pagination"`
+	Errata     []Erratum  `json:"body"`
+}
+type Erratum struct {
+	ID       string `json:"id"`
+	Synopsis string `json:"synopsis"`
+	Severity string `json:"severity"`
+	Type     string `json:"type"`
+	Issued   string `json:"issued"`
+}
+
+// Advisory is an erratum which ships the kernel package
+type Advisory struct {
+	ID       string
+	Severity string
+	Type     string
+}
 type DownloadInfo struct {
 	File FileInfo `json:"body"`
 }
@@ -59,10 +79,7 @@ type FileInfo struct {
 	Href       string    `json:"href"`
 }
 
-func RhPackageClient(logger logger.LeveledLogger, rhOfflineToken string) *http.Client {
-	restoredToken := &oauth2.Token{
-		RefreshToken: rhOfflineToken,
-	}
+func RhPackageClient(logger logger.LeveledLogger, rhOfflineToken string, tokenConfig RhTokenConfig) *http.Client {
 	// https://github.com/hashicorp/go-retryablehttp/pull/128#issuecomment-796527518
 	retryClient := retryablehttp.NewClient()
 	retryClient.RetryMax = 5
@@ -75,19 +92,23 @@ func RhPackageClient(logger logger.LeveledLogger, rhOfflineToken string) *http.C
 		return http.ErrUseLastResponse
 	}
 	parent := context.Background()
-	/*
-		oauth2 client will use RoundTripper from retryablehttp
-		https://github.com/golang/oauth2/issues/324
-		if v := client.Transport.(*oauth2.Transport).Base.(*retryablehttp.RoundTripper).Client; v != retryClient {
-			fmt.Printf("expected %v, got %v", retryClient, v)
-		}
-		Set reasonable timeout https://github.com/golang/oauth2/issues/368
-	*/
-	ctx := context.WithValue(parent, oauth2.HTTPClient, stdClient)
-	tokenSource := config.TokenSource(ctx, restoredToken)
-	oa2Client := oauth2.NewClient(ctx, tokenSource)
+	// Token exchange has its own timeout https://github.com/golang/oauth2/issues/368
+	tokenClient := &http.Client{
+		Transport: stdClient.Transport,
+		Timeout:   tokenConfig.Timeout,
+	}
+	tokenCtx := context.WithValue(parent, oauth2.HTTPClient, tokenClient)
+	tokenSource := newCachedTokenSource(tokenCtx, logger, tokenConfig, rhOfflineToken)
+	// oauth2.NewClient would wrap token source in oauth2.ReuseTokenSource
+	// which keeps token until it is about to expire, so cached token source
+	// is asked for token on every request to refresh it RefreshBefore expiry.
+	// API requests are not retried by transport, RhApiClient retries them
+	// itself respecting Retry-After.
 	client := &http.Client{
-		Transport: oa2Client.Transport,
+		Transport: &oauth2.Transport{
+			Source: tokenSource,
+			Base:   retryClient.HTTPClient.Transport,
+		},
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
@@ -95,91 +116,236 @@ func RhPackageClient(logger logger.LeveledLogger, rhOfflineToken string) *http.C
 	return client
 }
 
-func getRhelPackages(client *http.Client, repo, pattern string) ([]RhPackage, error) {
-	ctx := context.Background()
-	var mutex sync.RWMutex
+// RhApiClient is a client of Red Hat Subscription Management API
+type RhApiClient struct {
+	client     *http.Client
+	baseURL    string
+	arch       string
+	pageSize   int
+	maxRetries int
+}
+
+// IncompleteError is returned together with partial results when some of API
+// requests failed
+type IncompleteError struct {
+	Source string
+	Err    error
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("results from %s are incomplete: %v", e.Source, e.Err)
+}
+
+func (e *IncompleteError) Unwrap() error { return e.Err }
+
+// NewRhApiClient returns client listing packages of arch (x86_64 by
+// default)
+func NewRhApiClient(client *http.Client, baseURL, arch string) *RhApiClient {
+	if baseURL == "" {
+		baseURL = RH_API_URL
+	}
+	if arch == "" {
+		arch = ARCH_X86_64
+	}
+	return &RhApiClient{
+		client:     client,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		arch:       arch,
+		pageSize:   RH_API_PAGE_SIZE,
+		maxRetries: RH_API_RETRIES,
+	}
+}
+
+// ListPackages pages through content set and returns packages which name
+// matches the pattern. On failure already fetched packages are returned with
+// IncompleteError.
+func (c *RhApiClient) ListPackages(ctx context.Context, repo, pattern string) ([]RhPackage, error) {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
 	var rhPackages []RhPackage
-	r, _ := regexp.Compile(pattern)
-	g, _ := errgroup.WithContext(ctx)
-
-	for i := 0; i < RH_API_THREADS; i++ {
-		segmentNum := i * 100
-		g.Go(func() error {
-			offset := segmentNum
-
-			for {
-				var kernels []RhPackage
-				resp, err := getEntries(client, repo, offset)
-				if err != nil {
-					return err
-				}
-				for _, pkg := range resp.Packages {
-					if r.MatchString(pkg.Name) {
-						kernels = append(kernels, pkg)
-					}
+	err = c.paginate(repo, func(offset int) (Pagination, error) {
+		var page RepoContent
+		if err := c.get(ctx, c.packagesEndpoint(repo, offset), &page); err != nil {
+			return page.Pagination, err
+		}
+		for _, pkg := range page.Packages {
+			if r.MatchString(pkg.Name) {
+				rhPackages = append(rhPackages, pkg)
+			}
+		}
+		return page.Pagination, nil
+	})
+	return rhPackages, err
+}
+
+// ListErrataPackages finds packages matching the pattern in kernel bug fix and
+// security advisories published for content set. Advisories are returned
+// indexed by package file name.
+func (c *RhApiClient) ListErrataPackages(ctx context.Context, repo, pattern string) ([]RhPackage, map[string][]Advisory, error) {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	var errata []Erratum
+	err = c.paginate(repo, func(offset int) (Pagination, error) {
+		var page ErrataContent
+		if err := c.get(ctx, c.errataEndpoint(repo, offset), &page); err != nil {
+			return page.Pagination, err
+		}
+		for _, erratum := range page.Errata {
+			if erratum.isKernelFix() {
+				errata = append(errata, erratum)
+			}
+		}
+		return page.Pagination, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var rhPackages []RhPackage
+	advisories := make(map[string][]Advisory)
+	var incompleteErr error
+	for _, erratum := range errata {
+		err := c.paginate(erratum.ID, func(offset int) (Pagination, error) {
+			var page RepoContent
+			if err := c.get(ctx, c.erratumPackagesEndpoint(erratum.ID, offset), &page); err != nil {
+				return page.Pagination, err
+			}
+			for _, pkg := range page.Packages {
+				if !r.MatchString(pkg.Name) || pkg.Arch != c.arch || !pkg.inContentSet(repo) {
+					continue
 				}
-				mutex.Lock()
-				rhPackages = append(rhPackages, kernels...)
-				mutex.Unlock()
-				if resp.Pagination.Count < resp.Pagination.Limit {
-					break
+				fileName := pkg.fileName()
+				if _, ok := advisories[fileName]; !ok {
+					rhPackages = append(rhPackages, pkg)
 				}
-				offset = offset + RH_API_THREADS*100
+				advisories[fileName] = append(advisories[fileName], erratum.advisory())
 			}
-			return nil
+			return page.Pagination, nil
 		})
+		if err != nil {
+			// continue with other advisories, results are reported as incomplete
+			incompleteErr = err
+		}
 	}
+	return rhPackages, advisories, incompleteErr
+}
 
-	if err := g.Wait(); err != nil {
-		return rhPackages, fmt.Errorf("at least one request failed, results can be incomplite: %v", err)
+// paginate fetches consecutive pages until a page shorter than limit
+func (c *RhApiClient) paginate(source string, fetch func(offset int) (Pagination, error)) error {
+	offset := 0
+	for {
+		pagination, err := fetch(offset)
+		if err != nil {
+			return &IncompleteError{Source: source, Err: fmt.Errorf("offset %d: %v", offset, err)}
+		}
+		if pagination.Count == 0 || pagination.Count < pagination.Limit {
+			return nil
+		}
+		offset += pagination.Count
 	}
-	return rhPackages, nil
 }
 
-func getEndpoint(repo string, offset int) string {
-	return fmt.Sprintf("https://api.access.redhat.com/management/v1/packages/cset/%s/arch/x86_64?limit=100&offset=%d", repo, offset)
+// DownloadInfo returns signed download url of the package
+func (c *RhApiClient) DownloadInfo(ctx context.Context, p RhPackage) (FileInfo, error) {
+	var downloadInfo DownloadInfo
+	if err := c.get(ctx, p.DownloadHref, &downloadInfo); err != nil {
+		return FileInfo{}, err
+	}
+	return downloadInfo.File, nil
 }
-func getEntries(client *http.Client, repo string, offset int) (RepoContent, error) {
-	var response RepoContent
-	res, err := client.Get(getEndpoint(repo, offset))
-	if err != nil {
-		return response, err
+
+func (c *RhApiClient) packagesEndpoint(repo string, offset int) string {
+	return fmt.Sprintf("%s/packages/cset/%s/arch/%s?limit=%d&offset=%d", c.baseURL, repo, c.arch, c.pageSize, offset)
+}
+
+func (c *RhApiClient) errataEndpoint(repo string, offset int) string {
+	return fmt.Sprintf("%s/errata/cset/%s/arch/%s?limit=%d&offset=%d", c.baseURL, repo, c.arch, c.pageSize, offset)
+}
+
+func (c *RhApiClient) erratumPackagesEndpoint(advisoryID string, offset int) string {
+	return fmt.Sprintf("%s/errata/%s/packages?limit=%d&offset=%d", c.baseURL, advisoryID, c.pageSize, offset)
+}
+
+// get decodes json response. Transport errors and requests rejected with 429
+// or 5xx status are retried, Retry-After header is respected.
+func (c *RhApiClient) get(ctx context.Context, url string, v interface{}) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		backoff := time.Duration(1<<attempt) * time.Second
+		var wait time.Duration
+		res, err := c.client.Do(req)
+		if err != nil {
+			// connection resets and timeouts are retried with the same backoff
+			if ctx.Err() != nil || attempt >= c.maxRetries {
+				return err
+			}
+			wait = backoff
+		} else {
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				return fmt.Errorf("unable to read response from %s: %v", url, err)
+			}
+			if res.StatusCode == http.StatusOK {
+				if err := json.Unmarshal(body, v); err != nil {
+					return fmt.Errorf("unable to parse response from %s: %v", url, err)
+				}
+				return nil
+			}
+			retryable := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError
+			if !retryable || attempt >= c.maxRetries {
+				return fmt.Errorf("GET %s: %s %s", url, res.Status, strings.TrimSpace(string(body)))
+			}
+			wait = retryAfter(res.Header.Get("Retry-After"), backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
 	}
-	if res.Body != nil {
-		defer res.Body.Close()
+}
+
+// retryAfter parses Retry-After header which is either number of seconds or
+// http date
+func retryAfter(header string, fallback time.Duration) time.Duration {
+	if header == "" {
+		return fallback
 	}
-	body, readErr := ioutil.ReadAll(res.Body)
-	if readErr != nil {
-		log.Fatal(readErr)
-		return response, err
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
 	}
-	if err := json.Unmarshal(body, &response); err != nil {
-		return response, err
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+		return 0
 	}
-	return response, nil
+	return fallback
 }
 
-func parseRedHatPackages(client *http.Client, packages []RhPackage, version Version, parsers []string, artifactoryCache artifactory.ArtifactoryKernelCache) (map[string][]string, error) {
+// parseRedHatPackages returns kernel files and packages indexed by file url.
+// Signed download urls are resolved lazily, see Kernel.downloadURL.
+func parseRedHatPackages(packages []RhPackage, version Version, parsers []string) (map[string][]string, map[string]RhPackage, error) {
 	kernelMap := make(map[string][]string)
+	packageMap := make(map[string]RhPackage)
 	for _, rhp := range packages {
 		fileName := rhp.fileName()
 		for _, parser := range parsers {
 			valid, versionMatch, err := validateVersion(
 				fileName, parser, version.MinVersion, version.MaxVersion)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			if valid {
-				var downloadUrl string
-				if !artifactoryCache.Empty() && artifactoryCache.InCacheSumCheck(string(RHEL), version.Name, fileName, rhp.Checksum) {
-					// prevent additional request when package already in artifactory
-					downloadUrl = rhp.fileName()
-				} else {
-					downloadUrl, err = rhp.getAuthDownloadUrl(client)
-					if err != nil {
-						return nil, err
-					}
-				}
+				downloadUrl := rhp.lazyURL()
+				packageMap[downloadUrl] = rhp
 				if len(versionMatch) == 3 {
 					kernelMap[versionMatch[1]+"."+versionMatch[2]] = append(kernelMap[versionMatch[1]+"."+versionMatch[2]], downloadUrl)
 				} else {
@@ -190,29 +356,114 @@ func parseRedHatPackages(client *http.Client, packages []RhPackage, version Vers
 
 	}
 
-	return kernelMap, nil
+	return kernelMap, packageMap, nil
 }
 
 func (p RhPackage) fileName() string {
 	return fmt.Sprintf("%s-%s-%s.%s.rpm", p.Name, p.Version, p.Release, p.Arch)
 }
 
-func (p RhPackage) getAuthDownloadUrl(client *http.Client) (string, error) {
-	var downloadInfo DownloadInfo
-	res, err := client.Get(p.DownloadHref)
-	if err != nil {
-		return "", err
+func (p RhPackage) inContentSet(repo string) bool {
+	if len(p.ContentSets) == 0 {
+		return true
 	}
-	if res.Body != nil {
-		defer res.Body.Close()
+	for _, cs := range p.ContentSets {
+		if cs == repo {
+			return true
+		}
 	}
-	body, readErr := ioutil.ReadAll(res.Body)
-	if readErr != nil {
-		log.Fatal(readErr)
-		return "", err
+	return false
+}
+
+// isKernelFix matches security (RHSA) and bug fix (RHBA) kernel advisories
+func (e Erratum) isKernelFix() bool {
+	if !strings.HasPrefix(e.ID, "RHSA-") && !strings.HasPrefix(e.ID, "RHBA-") {
+		return false
+	}
+	return rhKernelSynopsis.MatchString(e.Synopsis)
+}
+
+func (e Erratum) advisory() Advisory {
+	return Advisory{ID: e.ID, Severity: e.Severity, Type: e.Type}
+}
+
+// Property formats advisory as "<id> <severity> <type>" to be stored with
+// cached kernel files, so advisories are known without RH API discovery
+func (a Advisory) Property() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", a.ID, a.Severity, a.Type))
+}
+
+func parseAdvisoryProperty(property string) Advisory {
+	fields := strings.SplitN(property, " ", 3)
+	advisory := Advisory{ID: fields[0]}
+	if len(fields) > 1 {
+		advisory.Severity = fields[1]
+	}
+	if len(fields) > 2 {
+		advisory.Type = fields[2]
+	}
+	return advisory
+}
+
+// AdvisoryProperties returns advisories of kernel formatted for cache
+func (k *Kernel) AdvisoryProperties() []string {
+	var properties []string
+	for _, a := range k.Advisories {
+		properties = append(properties, a.Property())
+	}
+	return properties
+}
+
+func (k *Kernel) addAdvisory(advisory Advisory) {
+	for _, a := range k.Advisories {
+		if a.ID == advisory.ID {
+			return
+		}
+	}
+	k.Advisories = append(k.Advisories, advisory)
+}
+
+func (p RhPackage) EVR() string {
+	if p.Epoch != "" && p.Epoch != "0" {
+		return fmt.Sprintf("%s:%s-%s", p.Epoch, p.Version, p.Release)
+	}
+	return fmt.Sprintf("%s-%s", p.Version, p.Release)
+}
+
+// lazyURL is a placeholder which is resolved to signed download url when file
+// is downloaded. File name is kept in fragment.
+func (p RhPackage) lazyURL() string {
+	return p.DownloadHref + "#" + p.fileName()
+}
+
+// downloadURL returns url from which kernel file can be downloaded. RedHat
+// signed urls are resolved on first use and again after they expire.
+func (k *Kernel) downloadURL(kernelFile string) (string, error) {
+	if k.rhClient == nil {
+		return kernelFile, nil
+	}
+	var rhp *RhPackage
+	for i := range k.RhPackages {
+		if k.RhPackages[i].lazyURL() == kernelFile {
+			rhp = &k.RhPackages[i]
+			break
+		}
+	}
+	if rhp == nil {
+		return kernelFile, nil
+	}
+	if info, ok := k.downloadInfo[kernelFile]; ok {
+		if info.Expiration.IsZero() || time.Now().Add(RH_URL_EXPIRY_MARGIN).Before(info.Expiration) {
+			return info.Href, nil
+		}
+	}
+	info, err := k.rhClient.DownloadInfo(context.Background(), *rhp)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve download url of %s: %v", rhp.fileName(), err)
 	}
-	if err := json.Unmarshal(body, &downloadInfo); err != nil {
-		return "", err
+	if k.downloadInfo == nil {
+		k.downloadInfo = make(map[string]FileInfo)
 	}
-	return downloadInfo.File.Href, nil
+	k.downloadInfo[kernelFile] = info
+	return info.Href, nil
 }
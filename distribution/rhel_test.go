@@ -0,0 +1,271 @@
+package distribution
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// fakeRhApi serves content set packages and errata of RHSM API
+type fakeRhApi struct {
+	packages []RhPackage
+	errata   []Erratum
+	// erratum id -> packages, erratum without packages fails with 500
+	errataPackages map[string][]RhPackage
+	requests       []string
+}
+
+func (f *fakeRhApi) handler() http.Handler {
+	mux := http.NewServeMux()
+	page := func(w http.ResponseWriter, r *http.Request, count int, body func(offset, end int) interface{}) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		end := offset + limit
+		if end > count {
+			end = count
+		}
+		if offset > end {
+			offset = end
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pagination": Pagination{Offset: offset, Limit: limit, Count: end - offset},
+			"body":       body(offset, end),
+		})
+	}
+	mux.HandleFunc("/packages/cset/rhel-8-for-x86_64-baseos-rpms/arch/x86_64", func(w http.ResponseWriter, r *http.Request) {
+		f.requests = append(f.requests, r.URL.RequestURI())
+		page(w, r, len(f.packages), func(offset, end int) interface{} { return f.packages[offset:end] })
+	})
+	mux.HandleFunc("/errata/cset/rhel-8-for-x86_64-baseos-rpms/arch/x86_64", func(w http.ResponseWriter, r *http.Request) {
+		f.requests = append(f.requests, r.URL.RequestURI())
+		page(w, r, len(f.errata), func(offset, end int) interface{} { return f.errata[offset:end] })
+	})
+	for _, erratum := range f.errata {
+		id := erratum.ID
+		mux.HandleFunc("/errata/"+id+"/packages", func(w http.ResponseWriter, r *http.Request) {
+			f.requests = append(f.requests, r.URL.RequestURI())
+			packages, ok := f.errataPackages[id]
+			if !ok {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			page(w, r, len(packages), func(offset, end int) interface{} { return packages[offset:end] })
+		})
+	}
+	return mux
+}
+
+func kernelDevel(release string) RhPackage {
+	return RhPackage{Name: "kernel-devel", Version: "4.18.0", Release: release, Arch: "x86_64"}
+}
+
+func TestListPackagesPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		packages int
+		requests int
+	}{
+		{name: "last page shorter than limit", packages: 5, requests: 3},
+		{name: "last page full", packages: 4, requests: 3},
+		{name: "empty content set", packages: 0, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeRhApi{}
+			for i := 0; i < tt.packages; i++ {
+				fake.packages = append(fake.packages, kernelDevel(fmt.Sprintf("%d.el8", i)), RhPackage{Name: "kernel-headers", Arch: "x86_64"})
+			}
+			server := httptest.NewServer(fake.handler())
+			defer server.Close()
+			client := NewRhApiClient(server.Client(), server.URL, "")
+			client.pageSize = 4
+			packages, err := client.ListPackages(context.Background(), "rhel-8-for-x86_64-baseos-rpms", "^kernel-devel$")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(packages) != tt.packages {
+				t.Errorf("got %d packages, want %d", len(packages), tt.packages)
+			}
+			if len(fake.requests) != tt.requests {
+				t.Errorf("got requests %v, want %d", fake.requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestGetRetryAfter(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer server.Close()
+	client := NewRhApiClient(server.Client(), server.URL, "")
+	start := time.Now()
+	var v map[string]string
+	if err := client.get(context.Background(), server.URL, &v); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, Retry-After is 1s", elapsed)
+	}
+	if v["status"] != "ok" {
+		t.Errorf("got %v", v)
+	}
+}
+
+func TestGetRetriesExhausted(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	client := NewRhApiClient(server.Client(), server.URL, "")
+	client.maxRetries = 2
+	var v map[string]string
+	if err := client.get(context.Background(), server.URL, &v); err == nil {
+		t.Fatal("expected error")
+	}
+	if attempts != 3 {
+		t.Errorf("got %d attempts, want 3", attempts)
+	}
+}
+
+func TestListErrataPackagesIncomplete(t *testing.T) {
+	fake := &fakeRhApi{
+		errata: []Erratum{
+			{ID: "RHSA-2023:0001", Synopsis: "Important: kernel security update", Severity: "Important"},
+			{ID: "RHBA-2023:0002", Synopsis: "kernel bug fix update", Severity: "None"},
+			{ID: "RHSA-2023:0003", Synopsis: "Moderate: openssl security update", Severity: "Moderate"},
+		},
+		errataPackages: map[string][]RhPackage{
+			"RHSA-2023:0001": {kernelDevel("1.el8"), {Name: "kernel-devel", Version: "4.18.0", Release: "1.el8", Arch: "aarch64"}},
+		},
+	}
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+	client := NewRhApiClient(server.Client(), server.URL, "")
+	client.maxRetries = 0
+	packages, advisories, err := client.ListErrataPackages(context.Background(), "rhel-8-for-x86_64-baseos-rpms", "^kernel-devel$")
+	var incompleteErr *IncompleteError
+	if !errors.As(err, &incompleteErr) {
+		t.Fatalf("got error %v, want IncompleteError", err)
+	}
+	if incompleteErr.Source != "RHBA-2023:0002" {
+		t.Errorf("got incomplete source %s", incompleteErr.Source)
+	}
+	if len(packages) != 1 || packages[0].fileName() != "kernel-devel-4.18.0-1.el8.x86_64.rpm" {
+		t.Errorf("got packages %v", packages)
+	}
+	if a := advisories["kernel-devel-4.18.0-1.el8.x86_64.rpm"]; len(a) != 1 || a[0].ID != "RHSA-2023:0001" {
+		t.Errorf("got advisories %v", advisories)
+	}
+	for _, request := range fake.requests {
+		if request == "/errata/RHSA-2023:0003/packages?limit=100&offset=0" {
+			t.Errorf("packages of non kernel advisory requested")
+		}
+	}
+}
+
+func TestAdvisoryProperty(t *testing.T) {
+	advisory := Advisory{ID: "RHSA-2023:0001", Severity: "Important", Type: "Security Advisory"}
+	if got := parseAdvisoryProperty(advisory.Property()); got != advisory {
+		t.Errorf("got %v, want %v", got, advisory)
+	}
+	if got := parseAdvisoryProperty("RHBA-2023:0002"); got.ID != "RHBA-2023:0002" || got.Severity != "" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestGetRetriesTransportErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			// connection is reset before response is sent
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer server.Close()
+	client := NewRhApiClient(server.Client(), server.URL, "")
+	var v map[string]string
+	if err := client.get(context.Background(), server.URL, &v); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || v["status"] != "ok" {
+		t.Errorf("got %d attempts, response %v", attempts, v)
+	}
+}
+
+func TestGetKernelListIncomplete(t *testing.T) {
+	fake := &fakeRhApi{packages: []RhPackage{kernelDevel("1.el8"), kernelDevel("2.el8")}}
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+	version := func(name, repo string) Version {
+		return Version{Name: name, MinVersion: "4.18.0-0", MaxVersion: "4.20.0-0", RhRepository: repo, RhApiURL: server.URL}
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tests := []struct {
+		name       string
+		required   string
+		kernels    int
+		incomplete bool
+	}{
+		{name: "other versions are discovered", required: "4.18.0-2.el8.x86_64", kernels: 2, incomplete: true},
+		{name: "required kernel of failed version", required: "4.18.0-305.el8.x86_64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Distribution{
+				Name:             string(RHEL),
+				Parser:           []string{`kernel-devel-(.+).(el\w+\.x86_64).rpm`},
+				RequiredVersions: []string{tt.required},
+				Versions: []Version{
+					// content set unknown to fake API fails with 404
+					version("9", "rhel-9-for-x86_64-baseos-rpms"),
+					version("8", "rhel-8-for-x86_64-baseos-rpms"),
+				},
+			}
+			kernels, err := d.GetKernelList(context.Background(), server.Client(), logger, false, nil)
+			var incompleteErr *IncompleteError
+			if err == nil || errors.As(err, &incompleteErr) != tt.incomplete {
+				t.Fatalf("got error %v, want incomplete %v", err, tt.incomplete)
+			}
+			if len(kernels) != tt.kernels {
+				t.Fatalf("got kernels %v", kernels)
+			}
+			for _, k := range kernels {
+				if k.Required != (k.Name+k.LocalVersion == tt.required) {
+					t.Errorf("kernel %s required %v", k.Name, k.Required)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package distribution
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/logger"
+)
+
+const (
+	RH_TOKEN_URL       = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+	RH_CLIENT_ID       = "rhsm-api"
+	RH_TOKEN_REFRESH   = 2 * time.Minute
+	RH_TOKEN_TIMEOUT   = 30 * time.Second
+	RH_TOKEN_CACHE_DIR = "kernel_downloader"
+)
+
+// RhTokenConfig configures exchange of offline token for access tokens
+type RhTokenConfig struct {
+	TokenURL string
+	ClientID string
+	// CacheDir keeps access tokens between runs, caching is disabled when empty
+	CacheDir string
+	// RefreshBefore is how long before expiry the access token is refreshed
+	RefreshBefore time.Duration
+	Timeout       time.Duration
+}
+
+// DefaultRhTokenConfig returns config for Red Hat SSO with tokens cached in
+// user cache directory
+func DefaultRhTokenConfig() RhTokenConfig {
+	cfg := RhTokenConfig{
+		TokenURL:      RH_TOKEN_URL,
+		ClientID:      RH_CLIENT_ID,
+		RefreshBefore: RH_TOKEN_REFRESH,
+		Timeout:       RH_TOKEN_TIMEOUT,
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		cfg.CacheDir = filepath.Join(cacheDir, RH_TOKEN_CACHE_DIR)
+	}
+	return cfg
+}
+
+func (c RhTokenConfig) oauth2Config() *oauth2.Config {
+	tokenURL := c.TokenURL
+	if tokenURL == "" {
+		tokenURL = RH_TOKEN_URL
+	}
+	clientID := c.ClientID
+	if clientID == "" {
+		clientID = RH_CLIENT_ID
+	}
+	return &oauth2.Config{
+		ClientID: clientID,
+		Scopes:   []string{"refresh_token"},
+		Endpoint: oauth2.Endpoint{TokenURL: tokenURL},
+	}
+}
+
+type cachedToken struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// cachedTokenSource shares access tokens obtained for the offline token
+// between concurrent invocations through a file guarded by a file lock
+type cachedTokenSource struct {
+	mutex         sync.Mutex
+	ctx           context.Context
+	logger        logger.LeveledLogger
+	config        *oauth2.Config
+	offlineToken  string
+	cacheFile     string
+	refreshBefore time.Duration
+	token         *oauth2.Token
+}
+
+func newCachedTokenSource(ctx context.Context, logger logger.LeveledLogger, cfg RhTokenConfig, offlineToken string) *cachedTokenSource {
+	source := &cachedTokenSource{
+		ctx:           ctx,
+		logger:        logger,
+		config:        cfg.oauth2Config(),
+		offlineToken:  offlineToken,
+		refreshBefore: cfg.RefreshBefore,
+	}
+	if cfg.CacheDir != "" {
+		// offline token is not stored, only used to distinguish cache files
+		sum := sha256.Sum256([]byte(source.config.Endpoint.TokenURL + source.config.ClientID + offlineToken))
+		source.cacheFile = filepath.Join(cfg.CacheDir, fmt.Sprintf("rh-token-%s.json", hex.EncodeToString(sum[:8])))
+	}
+	return source
+}
+
+func (s *cachedTokenSource) valid(token *oauth2.Token) bool {
+	return token != nil && token.AccessToken != "" && time.Now().Add(s.refreshBefore).Before(token.Expiry)
+}
+
+func (s *cachedTokenSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.valid(s.token) {
+		return s.token, nil
+	}
+	if s.cacheFile == "" {
+		token, err := s.exchange()
+		if err != nil {
+			return nil, err
+		}
+		s.token = token
+		return token, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.cacheFile), 0700); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	unlock, err := lockFile(s.cacheFile + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// other invocation could refresh token while we were waiting for the lock
+	if token, err := s.readCache(); err == nil && s.valid(token) {
+		s.logger.Debug("using cached RedHat access token", "expiry", token.Expiry)
+		s.token = token
+		return token, nil
+	}
+	token, err := s.exchange()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeCache(token); err != nil {
+		s.logger.Warn("unable to cache RedHat access token", "error", err)
+	}
+	s.token = token
+	return token, nil
+}
+
+func (s *cachedTokenSource) exchange() (*oauth2.Token, error) {
+	s.logger.Debug("exchanging RedHat offline token", "url", s.config.Endpoint.TokenURL)
+	// a new source is used every time so the token is not reused by oauth2
+	// until its expiry
+	token, err := s.config.TokenSource(s.ctx, &oauth2.Token{RefreshToken: s.offlineToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to exchange RedHat offline token: %v", err)
+	}
+	return token, nil
+}
+
+func (s *cachedTokenSource) readCache() (*oauth2.Token, error) {
+	data, err := os.ReadFile(s.cacheFile)
+	if err != nil {
+		return nil, err
+	}
+	var cached cachedToken
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: cached.AccessToken, TokenType: cached.TokenType, Expiry: cached.Expiry}, nil
+}
+
+func (s *cachedTokenSource) writeCache(token *oauth2.Token) error {
+	data, err := json.Marshal(cachedToken{AccessToken: token.AccessToken, TokenType: token.TokenType, Expiry: token.Expiry})
+	if err != nil {
+		return err
+	}
+	tmpFile := s.cacheFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.cacheFile)
+}
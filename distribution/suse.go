@@ -0,0 +1,33 @@
+package distribution
+
+import (
+	"fmt"
+	"strings"
+)
+
+// suseExtractCommand unpacks split SUSE kernel packages into kernel directory,
+// kernel-devel provides sources in /usr/src/linux-<ver> and
+// kernel-default-devel the build directory of default flavour in
+// /usr/src/linux-<ver>-obj/x86_64/default
+func (k *Kernel) suseExtractCommand(kernelDir string) (string, error) {
+	var devel, flavour bool
+	commands := []string{fmt.Sprintf("cd %s", kernelDir)}
+	for _, kernelFile := range k.Files {
+		fileName, err := destFileName(kernelFile)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case strings.HasPrefix(fileName, "kernel-default-devel-"):
+			flavour = true
+		case strings.HasPrefix(fileName, "kernel-devel-"):
+			devel = true
+		}
+		commands = append(commands, fmt.Sprintf("rpm2cpio %s/%s | cpio -idmv", kernelDir, fileName))
+	}
+	if !devel || !flavour {
+		return "", fmt.Errorf("kernel %s requires kernel-devel and kernel-default-devel packages", k.Name)
+	}
+	commands = append(commands, fmt.Sprintf("sed -i 's|/usr/src/|%s/usr/src/|g' %s/Makefile", kernelDir, k.KernelPath))
+	return strings.Join(commands, " && "), nil
+}
@@ -0,0 +1,32 @@
+package distribution
+
+import "time"
+
+// Phases of kernel module build, durations of every phase are recorded per
+// kernel and in total for reports
+const (
+	PHASE_DISCOVERY = "discovery"
+	PHASE_DOWNLOAD  = "download"
+	PHASE_EXTRACT   = "extract"
+	PHASE_COMPILE   = "compile"
+)
+
+// Phases lists build phases in order they are run
+var Phases = []string{PHASE_DISCOVERY, PHASE_DOWNLOAD, PHASE_EXTRACT, PHASE_COMPILE}
+
+// addDuration adds time elapsed since start to duration of kernel phase
+func (k *Kernel) addDuration(phase string, start time.Time) {
+	if k.Durations == nil {
+		k.Durations = make(map[string]time.Duration)
+	}
+	k.Durations[phase] += time.Since(start)
+}
+
+// Duration returns time spent by all phases of kernel build
+func (k *Kernel) Duration() time.Duration {
+	var total time.Duration
+	for _, d := range k.Durations {
+		total += d
+	}
+	return total
+}
@@ -0,0 +1,38 @@
+package distribution
+
+import "strings"
+
+const (
+	// UBUNTU_FLAVOR is the flavor of Ubuntu kernels when version doesn't
+	// define one
+	UBUNTU_FLAVOR = "generic"
+	// FLAVOR_PLACEHOLDER in parser is replaced with flavor of version
+	FLAVOR_PLACEHOLDER = "{flavor}"
+)
+
+// flavor returns kernel flavor (generic, lowlatency, aws, azure, gcp) of
+// version, only Ubuntu kernels have flavors
+func (v Version) flavor(distro string) string {
+	if distro != string(UBUNTU) {
+		return v.Flavor
+	}
+	if v.Flavor == "" {
+		return UBUNTU_FLAVOR
+	}
+	return v.Flavor
+}
+
+// parsers returns parsers of distribution for version with flavor and arch
+// placeholders replaced, e.g. linux-headers-(.+)-{flavor}_.+_{debarch}.deb
+func (d *Distribution) parsers(version Version) []string {
+	replacer := strings.NewReplacer(
+		FLAVOR_PLACEHOLDER, version.flavor(d.Name),
+		ARCH_PLACEHOLDER, version.arch(),
+		DEB_ARCH_PLACEHOLDER, archs[version.arch()].debArch,
+	)
+	var parsers []string
+	for _, parser := range d.Parser {
+		parsers = append(parsers, replacer.Replace(parser))
+	}
+	return parsers
+}
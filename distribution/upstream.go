@@ -0,0 +1,51 @@
+package distribution
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const (
+	// KERNEL_CONFIG_FILE is the name under which kernel config is stored next
+	// to the kernel sources
+	KERNEL_CONFIG_FILE = "linux_defconfig"
+)
+
+// isRemote checks if config source is downloaded together with kernel
+// sources or read from local file system when kernel is prepared
+func isRemote(source string) bool {
+	u, err := url.Parse(source)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// upstreamConfigFiles returns config and config fragments of the version
+// which have to be downloaded with kernel sources and names of config and
+// fragments used when kernel is prepared. Downloaded files are named relative
+// to kernel directory, local files are kept as they are.
+func upstreamConfigFiles(version Version) ([]string, string, []string) {
+	var files []string
+	config := version.KernelConfig
+	if isRemote(config) {
+		files = append(files, config+"#"+KERNEL_CONFIG_FILE)
+		config = KERNEL_CONFIG_FILE
+	}
+	fragmentFiles, fragments := configFragmentFiles(version.ConfigFragments, "")
+	files = append(files, fragmentFiles...)
+	return files, config, fragments
+}
+
+// configFragmentFiles returns remote fragments which have to be downloaded
+// with kernel sources and names of all fragments used when kernel is prepared
+func configFragmentFiles(configFragments []string, suffix string) ([]string, []string) {
+	var files []string
+	var fragments []string
+	for i, fragment := range configFragments {
+		if isRemote(fragment) {
+			name := fmt.Sprintf("fragment%s-%d.config", suffix, i)
+			files = append(files, fragment+"#"+name)
+			fragment = name
+		}
+		fragments = append(fragments, fragment)
+	}
+	return files, fragments
+}
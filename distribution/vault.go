@@ -0,0 +1,84 @@
+package distribution
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/logger"
+)
+
+// mirrorFileList discovers kernels of version on the live mirror at BaseURL
+// and on the vault at VaultURL. Packages are moved to the vault when a point
+// release is superseded, so kernels missing on the mirror (or the whole point
+// release directory) are taken from the vault. Kernel present in both is
+// downloaded from the mirror.
+func (d *Distribution) mirrorFileList(client *http.Client, logger logger.Logger, version Version) (map[string][]string, error) {
+	if version.VaultURL == "" {
+		fileList, err := hrefList(client, version.indexURL())
+		if err != nil {
+			return nil, err
+		}
+		return d.parse(fileList, version)
+	}
+	kernelMap := make(map[string][]string)
+	found := false
+	for _, baseURL := range []string{version.BaseURL, version.VaultURL} {
+		if baseURL == "" {
+			continue
+		}
+		content, ok, err := getHttpListing(client, baseURL)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			logger.Debugf("%s version %s not found at %s", d.Name, version.Name, baseURL)
+			continue
+		}
+		found = true
+		fileList, err := getFileList(content)
+		if err != nil {
+			return nil, err
+		}
+		sourceVersion := version
+		sourceVersion.BaseURL = baseURL
+		sourceMap, err := d.parse(fileList, sourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		for kernelName, files := range sourceMap {
+			if _, ok := kernelMap[kernelName]; ok {
+				continue
+			}
+			if baseURL == version.VaultURL {
+				logger.Debugf("kernel %s of %s version %s found in vault", kernelName, d.Name, version.Name)
+			}
+			kernelMap[kernelName] = files
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s version %s not found at %s nor %s", d.Name, version.Name, version.BaseURL, version.VaultURL)
+	}
+	return kernelMap, nil
+}
+
+// getHttpListing returns content of the page, ok is false when page doesn't
+// exist
+func getHttpListing(client *http.Client, url string) (string, bool, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return "", false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("unexpected status %s for %s", response.Status, url)
+	}
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", false, err
+	}
+	return string(content), true, nil
+}
@@ -0,0 +1,290 @@
+package localcache
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/artifactory"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/logger"
+)
+
+// ManifestFile is stored in the cache root and uses sha256sum(1) format, so
+// the tree can also be checked with `sha256sum -c SHA256SUMS`.
+const ManifestFile = "SHA256SUMS"
+
+// LocalCache keeps kernel sources in a plain directory tree with the same
+// <distro>/<version>/<file> layout used in the artifactory repository.
+type LocalCache struct {
+	dir string
+}
+
+func NewLocalCache(dir string) (LocalCache, error) {
+	var cache LocalCache
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return cache, err
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil && !os.IsExist(err) {
+		return cache, err
+	}
+	cache = LocalCache{absDir}
+	return cache, nil
+}
+
+func (c *LocalCache) Dir() string { return c.dir }
+
+// SyncFiles copies every file found under srcDir/<distro>/<version>/ into the
+// cache and records its checksum in the manifest.
+func (c *LocalCache) SyncFiles(logger logger.Logger, srcDir string) (int, int, error) {
+	manifest, err := readManifest(filepath.Join(c.dir, ManifestFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	synced, failed := 0, 0
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if _, _, _, err := splitCachePath(relPath); err != nil {
+			logger.Errorf("skipping %s: %v", path, err)
+			failed++
+			return nil
+		}
+		chksum, err := copyFile(path, filepath.Join(c.dir, relPath))
+		if err != nil {
+			logger.Errorf("unable to copy %s to cache: %v", path, err)
+			failed++
+			return nil
+		}
+		manifest[relPath] = chksum
+		synced++
+		return nil
+	})
+	if err != nil {
+		return synced, failed, err
+	}
+	return synced, failed, writeManifest(filepath.Join(c.dir, ManifestFile), manifest)
+}
+
+// GetKernels returns cache content based on the manifest. It is an equivalent
+// of artifactory.GetArtifactoryKernels.
+func (c *LocalCache) GetKernels(logger logger.Logger) (artifactory.ArtifactoryKernelCache, error) {
+	manifest, err := readManifest(filepath.Join(c.dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	return kernelCacheFromManifest(logger, manifest), nil
+}
+
+// Verify recalculates checksums of all files listed in the manifest.
+func (c *LocalCache) Verify(logger logger.Logger) error {
+	manifest, err := readManifest(filepath.Join(c.dir, ManifestFile))
+	if err != nil {
+		return err
+	}
+	var corrupted []string
+	for relPath, expected := range manifest {
+		chksum, err := fileChecksum(filepath.Join(c.dir, filepath.FromSlash(relPath)))
+		if err != nil {
+			logger.Errorf("unable to verify %s: %v", relPath, err)
+			corrupted = append(corrupted, relPath)
+			continue
+		}
+		if chksum != expected {
+			logger.Errorf("checksum mismatch for %s: expected %s, got %s", relPath, expected, chksum)
+			corrupted = append(corrupted, relPath)
+		}
+	}
+	if len(corrupted) > 0 {
+		sort.Strings(corrupted)
+		return fmt.Errorf("%d cached files failed verification: %v", len(corrupted), corrupted)
+	}
+	return nil
+}
+
+// Handler serves the cache tree as a static site. Directory listings are
+// compatible with the href scraping used for artifactory cache.
+func (c *LocalCache) Handler() http.Handler {
+	return http.FileServer(http.Dir(c.dir))
+}
+
+// RemoteCache is a cache served over http (see -serve). Files are listed from
+// its manifest, so their checksums are known before they are downloaded.
+type RemoteCache struct {
+	baseURL  string
+	manifest map[string]string
+}
+
+// NewRemoteCache fetches the manifest of a cache served over http, cache
+// without manifest can't be verified and is refused.
+func NewRemoteCache(client *http.Client, baseURL string) (*RemoteCache, error) {
+	manifest, err := fetchManifest(client, baseURL)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s not found in %s", ManifestFile, baseURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteCache{baseURL: strings.TrimSuffix(baseURL, "/"), manifest: manifest}, nil
+}
+
+// ListFiles returns files of distribution version listed in the manifest
+// indexed by file name.
+func (c *RemoteCache) ListFiles(distro, version string) (map[string]artifactory.CachedFile, error) {
+	files := make(map[string]artifactory.CachedFile)
+	for relPath, chksum := range c.manifest {
+		fileDistro, fileVersion, fileName, err := splitCachePath(relPath)
+		if err != nil || fileDistro != distro || fileVersion != version {
+			continue
+		}
+		files[fileName] = artifactory.CachedFile{
+			URL:    c.baseURL + "/" + relPath,
+			Sha256: chksum,
+		}
+	}
+	return files, nil
+}
+
+// fetchManifest reads the manifest of a cache served over http, missing
+// manifest is reported with os.ErrNotExist
+func fetchManifest(client *http.Client, baseURL string) (map[string]string, error) {
+	manifestURL := strings.TrimSuffix(baseURL, "/") + "/" + ManifestFile
+	resp, err := client.Get(manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %s: %s", manifestURL, resp.Status)
+	}
+	return parseManifest(resp.Body)
+}
+
+func kernelCacheFromManifest(logger logger.Logger, manifest map[string]string) artifactory.ArtifactoryKernelCache {
+	kernels := artifactory.NewKernelCache()
+	for relPath, chksum := range manifest {
+		distro, version, fileName, err := splitCachePath(relPath)
+		if err != nil {
+			logger.Errorf("can`t use this manifest entry: %s err: %v", relPath, err)
+			continue
+		}
+		kernels.Add(distro, version, fileName, chksum)
+	}
+	return kernels
+}
+
+func splitCachePath(relPath string) (string, string, string, error) {
+	pathEL := strings.Split(relPath, "/")
+	if len(pathEL) != 3 {
+		return "", "", "", fmt.Errorf("path does not follow <distro>/<version>/<file> layout: %s", relPath)
+	}
+	return pathEL[0], pathEL[1], pathEL[2], nil
+}
+
+func readManifest(manifestPath string) (map[string]string, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]string), nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return parseManifest(f)
+}
+
+func parseManifest(r io.Reader) (map[string]string, error) {
+	manifest := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed manifest line: %s", line)
+		}
+		// sha256sum marks binary mode with '*'
+		manifest[strings.TrimPrefix(fields[1], "*")] = fields[0]
+	}
+	return manifest, scanner.Err()
+}
+
+func writeManifest(manifestPath string, manifest map[string]string) error {
+	var paths []string
+	for relPath := range manifest {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+	tmpPath := manifestPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, relPath := range paths {
+		fmt.Fprintf(w, "%s  %s\n", manifest[relPath], relPath)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, manifestPath)
+}
+
+func copyFile(src, dst string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer srcFile.Close()
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer destFile.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(destFile, h), srcFile); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,52 @@
+package localcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoteCache(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "aaa  ubuntu/20.04/linux-headers-5.4.0-100_5.4.0-100.113_all.deb\n" +
+		"bbb  *minikube/v1.25.2/linux-4.19.202.tar.gz\n" +
+		"ccc  ubuntu/22.04/linux-headers-5.15.0-60_5.15.0-60.66_all.deb\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	cache, err := NewRemoteCache(server.Client(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := cache.ListFiles("ubuntu", "20.04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, ok := files["linux-headers-5.4.0-100_5.4.0-100.113_all.deb"]
+	if len(files) != 1 || !ok {
+		t.Fatalf("got files %v", files)
+	}
+	if file.Sha256 != "aaa" || file.URL != server.URL+"/ubuntu/20.04/linux-headers-5.4.0-100_5.4.0-100.113_all.deb" {
+		t.Errorf("got file %v", file)
+	}
+	files, err = cache.ListFiles("minikube", "v1.25.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files["linux-4.19.202.tar.gz"].Sha256 != "bbb" {
+		t.Errorf("got files %v", files)
+	}
+}
+
+func TestRemoteCacheWithoutManifest(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir(t.TempDir())))
+	defer server.Close()
+	if _, err := NewRemoteCache(server.Client(), server.URL); err == nil {
+		t.Fatal("expected error for cache without manifest")
+	}
+}
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v3"
 	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/artifactory"
 	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/distribution"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/localcache"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/ociregistry"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/prune"
 	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/report"
 
 	logrus "github.com/sirupsen/logrus"
@@ -43,19 +53,47 @@ func (f *OutputFormats) Get() []map[string]string {
 	return f.formats
 }
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 var (
 	kernelDefinitions  string
 	artifactoryBaseURL string
 	artSync            bool
+	cacheDir           string
+	cacheURL           string
+	serveAddr          string
+	ociRegistryURL     string
+	pruneCache         bool
+	pruneDelete        bool
+	dockerfiles        bool
+	prunePolicy        prune.Policy
+	rhTokenConfig      = distribution.DefaultRhTokenConfig()
 	logLevel           string
 	reportFormats      OutputFormats
+	reportLinkBase     string
 )
 
 func init() {
 	flag.StringVar(&kernelDefinitions, "config", "./kernellist.yaml", "Defintions of kernel versions for which vrouter module should be built.")
 	flag.StringVar(&artifactoryBaseURL, "artbaseurl", "https://svl-artifactory.juniper.net/artifactory/", "Artifactory base url")
 	flag.BoolVar(&artSync, "artsync", false, "Upload to artifactory upstream kernel sources. Requires ARTIFACTORY_TOKEN env variable")
-	flag.Var(&reportFormats, "format", "format_name[,output file path]. Known formats: table, json, yaml, csv")
+	flag.StringVar(&cacheDir, "cachedir", "", "Local directory used as kernel sources cache instead of artifactory. Used by -artsync and -serve")
+	flag.StringVar(&cacheURL, "cacheurl", "", "Base url of kernel sources cache served over http (see -serve), used instead of artifactory")
+	flag.StringVar(&serveAddr, "serve", "", "Serve -cachedir over http on given address (e.g. :8080)")
+	flag.StringVar(&ociRegistryURL, "ociregistry", "", "OCI registry used as kernel sources cache instead of artifactory, e.g. localhost:5000/cn2/kernels. Credentials can be passed by OCI_USERNAME and OCI_PASSWORD env variables")
+	flag.BoolVar(&pruneCache, "prune", false, "Report artifactory kernel sources which are not needed by current config. Dry-run unless -prunedelete is set")
+	flag.BoolVar(&pruneDelete, "prunedelete", false, "Delete orphaned kernel sources found by -prune. Requires ARTIFACTORY_TOKEN env variable")
+	flag.BoolVar(&prunePolicy.KeepRequired, "keeprequired", true, "Prune retention: keep sources of kernels listed in requiredVersions")
+	flag.IntVar(&prunePolicy.KeepLast, "keeplast", 0, "Prune retention: keep N most recently uploaded kernels per distribution version")
+	flag.DurationVar(&prunePolicy.GracePeriod, "graceperiod", 7*24*time.Hour, "Prune retention: keep files uploaded within this period")
+	flag.StringVar(&rhTokenConfig.TokenURL, "rhtokenurl", rhTokenConfig.TokenURL, "Token endpoint used to exchange RH_OFFLINE_TOKEN for access tokens")
+	flag.StringVar(&rhTokenConfig.ClientID, "rhclientid", rhTokenConfig.ClientID, "Client ID used to exchange RH_OFFLINE_TOKEN for access tokens")
+	flag.StringVar(&rhTokenConfig.CacheDir, "rhtokencache", rhTokenConfig.CacheDir, "Directory where RedHat access tokens are cached between runs, empty disables caching")
+	flag.DurationVar(&rhTokenConfig.RefreshBefore, "rhtokenrefresh", rhTokenConfig.RefreshBefore, "Refresh RedHat access token this long before it expires")
+	flag.BoolVar(&dockerfiles, "dockerfiles", false, "Only generate images/Dockerfile.<kernel> for every kernel. Kernel sources are downloaded and extracted when images are built, nothing is compiled and no report is written")
+	flag.Var(&reportFormats, "format", "format_name[,output file path]. Known formats: table, json, yaml, csv, html, junit")
+	flag.StringVar(&reportLinkBase, "reportlinkbase", "", "Prefix of module and build log links in html report, e.g. url where /kernelmodules is published")
 	flag.StringVar(&logLevel, "loglevel", "info", "Log level: panic, fatal, error, warn, info, debug, trace")
 }
 
@@ -73,12 +111,20 @@ func mainWithReturnCode() int {
 		FullTimestamp: true,
 	})
 
+	// discovery is cancelled on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	start := time.Now()
 	artToken := os.Getenv("ARTIFACTORY_TOKEN")
 	rhOfflineToken := os.Getenv("RH_OFFLINE_TOKEN")
 	var kernelListTotal []*distribution.Kernel
+	var incompleteSources []string
+	phases := make(map[string]time.Duration)
 	var existingKernels artifactory.ArtifactoryKernelCache
 	var artMgr artifactory.ArtifactoryManger
+	var localCache localcache.LocalCache
+	var ociRegistry ociregistry.Registry
 	retryClient := distribution.GetHttpClientWithRetry(&logging.LeveledLogrus{logger}, 10)
 
 	fileByte, err := os.ReadFile(kernelDefinitions)
@@ -89,8 +135,88 @@ func mainWithReturnCode() int {
 	if err := yaml.Unmarshal(fileByte, &distributions); err != nil {
 		logger.Fatal(err)
 	}
+	if err := distributions.Validate(); err != nil {
+		logger.Fatal(err)
+	}
 
-	if artSync {
+	if cacheDir != "" {
+		localCache, err = localcache.NewLocalCache(cacheDir)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if serveAddr != "" {
+		if cacheDir == "" {
+			logger.Fatal("-serve requires -cachedir")
+		}
+		if err := localCache.Verify(logger); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Infof("serving kernel cache %s on %s", localCache.Dir(), serveAddr)
+		if err := http.ListenAndServe(serveAddr, localCache.Handler()); err != nil {
+			logger.Error(err)
+			return 1
+		}
+		return 0
+	}
+
+	if ociRegistryURL != "" {
+		ociRegistry, err = ociregistry.NewRegistry(retryClient, ociRegistryURL, os.Getenv("OCI_USERNAME"), os.Getenv("OCI_PASSWORD"))
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if pruneCache {
+		if distributions.ArtifactoryRepo == "" {
+			logger.Fatal("artifactoryRepo not set in config file")
+		}
+		if pruneDelete && artToken == "" {
+			logger.Fatal("ARTIFACTORY_TOKEN env variable not set")
+		}
+		artMgr, err = artifactory.NewArtifactoryManger(&logging.LogrousWithOutput{Logger: logger}, artifactoryBaseURL, artToken)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		files, err := artMgr.GetArtifactoryFiles(logger, distributions.ArtifactoryRepo)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		plan, err := prune.NewPlan(distributions, files, prunePolicy, time.Now())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(plan.TableReport())
+		if !pruneDelete {
+			logger.Infof("Dry-run, %d files would be deleted", len(plan.ToDelete()))
+			return 0
+		}
+		deleted, err := artMgr.DeleteFiles(plan.ToDelete())
+		logger.Infof("Deleted: %d, Error: %v", deleted, err)
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	if artSync && ociRegistryURL != "" {
+		distroVersions := make(map[string][]string)
+		for _, distro := range distributions.Distributions {
+			for _, version := range distro.Versions {
+				distroVersions[distro.Name] = append(distroVersions[distro.Name], version.Name)
+			}
+		}
+		existingKernels, err = ociRegistry.GetKernels(logger, distroVersions)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	} else if artSync && cacheDir != "" {
+		existingKernels, err = localCache.GetKernels(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	} else if artSync {
 		if artToken == "" {
 			logger.Fatal("ARTIFACTORY_TOKEN env variable not set")
 		}
@@ -105,29 +231,64 @@ func mainWithReturnCode() int {
 		if err != nil {
 			logger.Fatal(err)
 		}
+	} else if cacheURL == "" && ociRegistryURL == "" {
+		// artifactory cache is listed with search API, without ARTIFACTORY_TOKEN
+		// repository is accessed anonymously
+		artMgr, err = artifactory.NewArtifactoryManger(&logging.LogrousWithOutput{Logger: logger}, artifactoryBaseURL, artToken)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	var remoteCache *localcache.RemoteCache
+	if !artSync && cacheURL != "" {
+		// checksums of served files are read from its manifest
+		remoteCache, err = localcache.NewRemoteCache(retryClient, cacheURL)
+		if err != nil {
+			logger.Fatal(err)
+		}
 	}
 
+	discoveryStart := time.Now()
 	for _, distro := range distributions.Distributions {
 		httpClient := retryClient
-		if !artSync {
+		if !artSync && ociRegistryURL != "" {
+			// minikube sources are always fetched from upstream
+			if distro.Name != string(distribution.MINIKUBE) {
+				distro.UseFileCache(&ociRegistry)
+			}
+		} else if !artSync && cacheURL != "" {
+			if err := distro.UseArtifactoryCache(strings.TrimSuffix(cacheURL, "/") + "/"); err != nil {
+				logger.Fatal(err)
+			}
+			distro.UseFileCache(remoteCache)
+		} else if !artSync {
 			if err := distro.UseArtifactoryCache(fmt.Sprintf("%s/%s", artifactoryBaseURL, distributions.ArtifactoryRepo)); err != nil {
 				logger.Fatal(err)
 			}
+			if distro.Name != string(distribution.MINIKUBE) {
+				distro.UseFileCache(artMgr.RepoCache(retryClient, artifactoryBaseURL, distributions.ArtifactoryRepo))
+			}
 		}
 		if distro.Name == string(distribution.RHEL) && artSync {
 			if rhOfflineToken == "" {
 				logger.Error("RH_OFFLINE_TOKEN env variable not defined")
 				continue
 			} else {
-				httpClient = distribution.RhPackageClient(&logging.LeveledLogrus{logger}, rhOfflineToken)
+				httpClient = distribution.RhPackageClient(&logging.LeveledLogrus{Logger: logger}, rhOfflineToken, rhTokenConfig)
 			}
 		}
-		kernelList, err := distro.GetKernelList(httpClient, logger, artSync, existingKernels)
-		if err != nil {
+		kernelList, err := distro.GetKernelList(ctx, httpClient, logger, artSync, existingKernels)
+		var incompleteErr *distribution.IncompleteError
+		if errors.As(err, &incompleteErr) {
+			logger.Error(err)
+			incompleteSources = append(incompleteSources, fmt.Sprintf("%s (%s)", distro.Name, incompleteErr.Source))
+		} else if err != nil {
 			logger.Fatal(err)
 		}
 		kernelListTotal = append(kernelListTotal, kernelList...)
 	}
+	phases[distribution.PHASE_DISCOVERY] = time.Since(discoveryStart)
 
 	if artSync {
 		tempDir, err := ioutil.TempDir("", "kernels-")
@@ -137,6 +298,9 @@ func mainWithReturnCode() int {
 		}
 		defer os.RemoveAll(tempDir)
 		downloadCount := 0
+		pushedKernels := make(map[string]struct{})
+		var kernelFiles []artifactory.KernelFile
+		uploadedFiles := make(map[string]struct{})
 		for _, kernel := range kernelListTotal {
 			if kernel.Downloaded {
 				logger.Debugf("%s-%s: %s already in artifactory chache, or cache not enabled", kernel.Distro, kernel.DistroVersion, kernel.Name)
@@ -144,11 +308,52 @@ func mainWithReturnCode() int {
 			}
 			if err := kernel.Download(retryClient, logger, tempDir); err != nil {
 				logger.Error(err)
+				continue
 			}
 			downloadCount++
+			for fileLocation, upstreamURL := range kernel.FileLocation {
+				if _, ok := uploadedFiles[fileLocation]; ok {
+					continue
+				}
+				uploadedFiles[fileLocation] = struct{}{}
+				kernelFiles = append(kernelFiles, artifactory.KernelFile{
+					LocalPath:      fileLocation,
+					Distro:         string(kernel.Distro),
+					DistroVersion:  kernel.DistroVersion,
+					Kernel:         kernel.Name,
+					UpstreamURL:    upstreamURL,
+					UpstreamSha256: kernel.UpstreamSha256[filepath.Base(fileLocation)],
+					Advisories:     kernel.AdvisoryProperties(),
+				})
+			}
+			kernelKey := fmt.Sprintf("%s/%s/%s", kernel.Distro, kernel.DistroVersion, kernel.Name)
+			if _, ok := pushedKernels[kernelKey]; ociRegistryURL == "" || ok {
+				continue
+			}
+			artifact := ociregistry.KernelArtifact{
+				Distro:        string(kernel.Distro),
+				DistroVersion: kernel.DistroVersion,
+				Kernel:        kernel.Name,
+				EVR:           kernel.EVR(),
+				Advisories:    kernel.AdvisoryProperties(),
+			}
+			for fileLocation := range kernel.FileLocation {
+				artifact.Files = append(artifact.Files, fileLocation)
+			}
+			sort.Strings(artifact.Files)
+			if err := ociRegistry.PushKernel(logger, artifact); err != nil {
+				logger.Error(err)
+				continue
+			}
+			pushedKernels[kernelKey] = struct{}{}
 		}
-		if downloadCount > 0 {
-			totalUploaded, totalFailed, err := artMgr.UploadFiles(filepath.Join(distributions.ArtifactoryRepo, "{1}/"), filepath.Join(tempDir, "(**)/"))
+		if ociRegistryURL != "" {
+			logger.Infof("Pushed to %s: %d kernels", ociRegistryURL, len(pushedKernels))
+		} else if downloadCount > 0 && cacheDir != "" {
+			totalSynced, totalFailed, err := localCache.SyncFiles(logger, tempDir)
+			logger.Infof("Synced to %s: %d, Failed %d, Error: %v\n", localCache.Dir(), totalSynced, totalFailed, err)
+		} else if downloadCount > 0 {
+			totalUploaded, totalFailed, err := artMgr.UploadKernelFiles(distributions.ArtifactoryRepo, kernelFiles, version)
 			logger.Infof("Uploaded: %d, Failed %d, Error: %v\n", totalUploaded, totalFailed, err)
 		} else {
 			logger.Info("Nothing to upload")
@@ -156,44 +361,77 @@ func mainWithReturnCode() int {
 		return 0
 	}
 
-	for _, kernel := range kernelListTotal {
-		if err := kernel.DownloadAndExtract(retryClient, logger); err != nil {
-			logger.Error(err)
+	// kernels with the same header tree are downloaded and built once
+	kernelGroups := distribution.GroupKernels(retryClient, kernelListTotal)
+	extractStart := time.Now()
+	for _, group := range kernelGroups {
+		if len(group.Shared) > 0 {
+			logger.Infof("kernel %s shared by %d other distribution versions", group.Kernel.ID(), len(group.Shared))
 		}
-	}
-
-	for _, kernel := range kernelListTotal {
-		baseString := `FROM debian:stretch
-RUN apt update && apt install -y rpm2cpio cpio curl
-`
-		for k, v := range kernel.FileLocation {
-			baseString += fmt.Sprintf("ADD %s %s\n", v, k)
+		if dockerfiles {
+			// files prepared on the host are copied to the image from build
+			// context of generated Dockerfiles
+			group.Kernel.ContextDir = "images"
 		}
-		dockerfile := fmt.Sprintf("images/Dockerfile.%s", kernel.Name)
-		baseString += fmt.Sprintf("RUN %s\n", kernel.Command)
-		baseString += fmt.Sprintf("RUN echo %s > /kernelpath\n", kernel.KernelPath)
-		if err := os.WriteFile(dockerfile, []byte(baseString), 0644); err != nil {
-			fmt.Println(err)
+		if err := group.Kernel.DownloadAndExtract(retryClient, logger); err != nil {
+			logger.Error(err)
+		} else if !dockerfiles {
+			if err := group.Kernel.ExtractOnHost(retryClient, logger); err != nil {
+				logger.Error(err)
+			}
 		}
-
+		group.Link()
+		phases[distribution.PHASE_DOWNLOAD] += group.Kernel.Durations[distribution.PHASE_DOWNLOAD]
 	}
+	// kernels are downloaded and extracted one by one
+	phases[distribution.PHASE_EXTRACT] = time.Since(extractStart) - phases[distribution.PHASE_DOWNLOAD]
 
-	os.Exit(0)
+	if dockerfiles {
+		writeDockerfiles(logger, kernelGroups)
+		return 0
+	}
 
-	for _, kernel := range kernelListTotal {
+	compileStart := time.Now()
+	for _, group := range kernelGroups {
+		kernel := group.Kernel
 		if kernel.Extracted && kernel.Downloaded {
 			if err := kernel.Compile(logger); err != nil {
 				logger.Error(err)
 			}
 		}
+		group.Link()
 	}
+	phases[distribution.PHASE_COMPILE] = time.Since(compileStart)
 	result := report.Result{
-		Kernels: kernelListTotal,
-		Start:   start,
-		End:     time.Now(),
+		Kernels:    kernelListTotal,
+		Start:      start,
+		End:        time.Now(),
+		Incomplete: incompleteSources,
+		Phases:     phases,
+		LinkBase:   reportLinkBase,
+	}
+
+	if code := writeReports(logger, os.Stdout, result, reportFormats.Get()); code != 0 {
+		return code
+	}
+
+	var missingRequired []string
+	for _, k := range result.Kernels {
+		if k.Required && k.Compiled == distribution.FAIL {
+			missingRequired = append(missingRequired, (k.Name + k.LocalVersion))
+		}
+	}
+	if len(missingRequired) > 0 {
+		logger.Fatalf("List of needed kernels for which vrouter module did not compile: %v", missingRequired)
 	}
+	return 0
+}
 
-	for _, format := range reportFormats.Get() {
+// writeReports renders result in every requested format and writes it to the
+// output file of the format or to stdout. Exit code 2 is returned when report
+// can't be rendered and 3 when it can't be written.
+func writeReports(logger logging.Logger, stdout io.Writer, result report.Result, formats []map[string]string) int {
+	for _, format := range formats {
 		for rType, outFile := range format {
 			var err error
 			var report string
@@ -206,6 +444,10 @@ RUN apt update && apt install -y rpm2cpio cpio curl
 				report, err = result.JsonReport()
 			case "yaml":
 				report, err = result.YamlReport()
+			case "html":
+				report, err = result.HtmlReport()
+			case "junit":
+				report, err = result.JunitReport()
 			default:
 				err = fmt.Errorf("unknow report format: %s", rType)
 			}
@@ -220,19 +462,45 @@ RUN apt update && apt install -y rpm2cpio cpio curl
 					return 3
 				}
 			} else {
-				fmt.Println(report)
+				fmt.Fprintln(stdout, report)
 			}
 		}
 	}
+	return 0
+}
 
-	var missingRequired []string
-	for _, k := range result.Kernels {
-		if k.Required && k.Compiled == distribution.FAIL {
-			missingRequired = append(missingRequired, (k.Name + k.LocalVersion))
+// writeDockerfiles generates images/Dockerfile.<kernel> for every built kernel,
+// kernel files are added from their urls and extracted when image is built
+func writeDockerfiles(logger *logrus.Logger, kernelGroups []*distribution.KernelGroup) {
+	for _, group := range kernelGroups {
+		kernel := group.Kernel
+		baseString := `FROM debian:stretch
+RUN apt update && apt install -y rpm2cpio cpio curl
+`
+		verified := false
+		for k, v := range kernel.FileLocation {
+			// files with known checksum are verified when image is built
+			if chksum := kernel.Checksums[filepath.Base(k)]; chksum != "" {
+				baseString += fmt.Sprintf("ADD --checksum=sha256:%s %s %s\n", chksum, v, k)
+				verified = true
+				continue
+			}
+			baseString += fmt.Sprintf("ADD %s %s\n", v, k)
+		}
+		if verified {
+			// ADD --checksum needs dockerfile syntax 1.6
+			baseString = "# syntax=docker/dockerfile:1.6\n" + baseString
+		}
+		for k, v := range kernel.ContextFiles {
+			baseString += fmt.Sprintf("COPY %s %s\n", k, v)
+		}
+		dockerfile := fmt.Sprintf("images/Dockerfile.%s", kernel.DirName())
+		if kernel.Command != "" {
+			baseString += fmt.Sprintf("RUN %s\n", kernel.Command)
+		}
+		baseString += fmt.Sprintf("RUN echo %s > /kernelpath\n", kernel.KernelPath)
+		if err := os.WriteFile(dockerfile, []byte(baseString), 0644); err != nil {
+			logger.Error(err)
 		}
 	}
-	if len(missingRequired) > 0 {
-		logger.Fatalf("List of needed kernels for which vrouter module did not compile: %v", missingRequired)
-	}
-	return 0
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/distribution"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/report"
+)
+
+func TestWriteReports(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	result := report.Result{
+		Kernels: []*distribution.Kernel{
+			{Name: "5.4.0-100", LocalVersion: "-generic", Distro: distribution.UBUNTU, DistroVersion: "20.04", Downloaded: true, Extracted: true, Compiled: true},
+			{Name: "5.4.0-101", LocalVersion: "-generic", Distro: distribution.UBUNTU, DistroVersion: "20.04", Downloaded: true, Extracted: true, Errormsg: "make failed"},
+		},
+		Start: time.Now().Add(-time.Minute),
+		End:   time.Now(),
+		Phases: map[string]time.Duration{
+			distribution.PHASE_DISCOVERY: time.Second,
+			distribution.PHASE_DOWNLOAD:  2 * time.Second,
+			distribution.PHASE_EXTRACT:   3 * time.Second,
+			distribution.PHASE_COMPILE:   4 * time.Second,
+		},
+	}
+	dir := t.TempDir()
+	var formats OutputFormats
+	for _, format := range []string{"html," + filepath.Join(dir, "report.html"), "junit," + filepath.Join(dir, "junit.xml"), "table"} {
+		if err := formats.Set(format); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stdout bytes.Buffer
+	if code := writeReports(logger, &stdout, result, formats.Get()); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+
+	html, err := os.ReadFile(filepath.Join(dir, "report.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, phase := range distribution.Phases {
+		if !strings.Contains(string(html), "<td>"+phase+"</td>") {
+			t.Errorf("phase %s missing in html report", phase)
+		}
+	}
+	junit, err := os.ReadFile(filepath.Join(dir, "junit.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+	}
+	if err := xml.Unmarshal(junit, &suites); err != nil {
+		t.Fatal(err)
+	}
+	if suites.Tests != 2 || suites.Failures != 1 {
+		t.Errorf("got %d tests and %d failures in junit report", suites.Tests, suites.Failures)
+	}
+	if !strings.Contains(stdout.String(), "5.4.0-100") {
+		t.Errorf("table report not written to stdout: %s", stdout.String())
+	}
+}
+
+func TestWriteReportsUnknownFormat(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	var formats OutputFormats
+	formats.Set("pdf")
+	if code := writeReports(logger, io.Discard, report.Result{}, formats.Get()); code != 2 {
+		t.Errorf("got exit code %d, want 2", code)
+	}
+}
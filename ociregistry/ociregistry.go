@@ -0,0 +1,520 @@
+package ociregistry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/artifactory"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/logger"
+)
+
+const (
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ConfigMediaType   = "application/vnd.juniper.cn2.kernel.config.v1+json"
+	FileMediaType     = "application/vnd.juniper.cn2.kernel.file.v1"
+
+	// AnnotationTitle is the file name annotation used by ORAS
+	AnnotationTitle         = "org.opencontainers.image.title"
+	AnnotationKernel        = "net.juniper.cn2.kernel.name"
+	AnnotationDistro        = "net.juniper.cn2.kernel.distro"
+	AnnotationDistroVersion = "net.juniper.cn2.kernel.distroVersion"
+	AnnotationEVR           = "net.juniper.cn2.kernel.evr"
+	AnnotationSha256        = "net.juniper.cn2.kernel.sha256"
+	// AnnotationAdvisories lists advisories shipping the kernel separated by
+	// comma
+	AnnotationAdvisories = "net.juniper.cn2.kernel.advisories"
+)
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type tagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// KernelArtifact describes kernel source files pushed as a single OCI artifact
+type KernelArtifact struct {
+	Distro        string   `json:"distro"`
+	DistroVersion string   `json:"distroVersion"`
+	Kernel        string   `json:"kernel"`
+	EVR           string   `json:"evr,omitempty"`
+	Files         []string `json:"-"`
+	Advisories    []string `json:"advisories,omitempty"`
+}
+
+// Registry stores kernel sources in any OCI Distribution-spec registry. Every
+// distribution version is a repository <prefix>/<distro>/<version> and every
+// kernel is a tag in it.
+type Registry struct {
+	client   *http.Client
+	baseURL  *url.URL
+	prefix   string
+	username string
+	password string
+	tokens   *tokenCache
+}
+
+type tokenCache struct {
+	sync.Mutex
+	tokens map[string]string
+}
+
+var (
+	invalidTagChars  = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	challengeParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// NewRegistry accepts registry url in form [scheme://]host[:port]/prefix,
+// https is used when scheme is omitted.
+func NewRegistry(client *http.Client, registryURL, username, password string) (Registry, error) {
+	var reg Registry
+	if !strings.Contains(registryURL, "://") {
+		registryURL = "https://" + registryURL
+	}
+	u, err := url.Parse(registryURL)
+	if err != nil {
+		return reg, err
+	}
+	if u.Host == "" {
+		return reg, fmt.Errorf("registry host missing in %s", registryURL)
+	}
+	reg = Registry{
+		client:   client,
+		baseURL:  &url.URL{Scheme: u.Scheme, Host: u.Host},
+		prefix:   strings.ToLower(strings.Trim(u.Path, "/")),
+		username: username,
+		password: password,
+		tokens:   &tokenCache{tokens: make(map[string]string)},
+	}
+	return reg, nil
+}
+
+func (r *Registry) repository(distro, version string) string {
+	return strings.ToLower(path.Join(r.prefix, distro, version))
+}
+
+func kernelTag(kernel string) string {
+	tag := invalidTagChars.ReplaceAllString(kernel, "_")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return tag
+}
+
+func (r *Registry) endpoint(repo, suffix string) string {
+	return r.baseURL.ResolveReference(&url.URL{Path: fmt.Sprintf("/v2/%s/%s", repo, suffix)}).String()
+}
+
+// PushKernel uploads every kernel file as a layer and tags the manifest with
+// the kernel name.
+func (r *Registry) PushKernel(logger logger.Logger, artifact KernelArtifact) error {
+	repo := r.repository(artifact.Distro, artifact.DistroVersion)
+	var layers []Descriptor
+	for _, file := range artifact.Files {
+		desc, err := fileDescriptor(file)
+		if err != nil {
+			return err
+		}
+		desc.Annotations[AnnotationSha256] = strings.TrimPrefix(desc.Digest, "sha256:")
+		logger.Debugf("pushing %s to %s as %s", file, repo, desc.Digest)
+		if err := r.pushBlob(repo, desc, func() (io.ReadCloser, error) { return os.Open(file) }); err != nil {
+			return fmt.Errorf("unable to push %s: %v", file, err)
+		}
+		layers = append(layers, desc)
+	}
+	config, err := json.Marshal(artifact)
+	if err != nil {
+		return err
+	}
+	configDesc := bytesDescriptor(ConfigMediaType, config)
+	if err := r.pushBlob(repo, configDesc, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(config)), nil
+	}); err != nil {
+		return fmt.Errorf("unable to push config for %s: %v", artifact.Kernel, err)
+	}
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		Config:        configDesc,
+		Layers:        layers,
+		Annotations: map[string]string{
+			AnnotationKernel:        artifact.Kernel,
+			AnnotationDistro:        artifact.Distro,
+			AnnotationDistroVersion: artifact.DistroVersion,
+		},
+	}
+	if artifact.EVR != "" {
+		manifest.Annotations[AnnotationEVR] = artifact.EVR
+	}
+	if len(artifact.Advisories) > 0 {
+		manifest.Annotations[AnnotationAdvisories] = strings.Join(artifact.Advisories, ",")
+	}
+	manifestByte, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(repo, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, r.endpoint(repo, "manifests/"+kernelTag(artifact.Kernel)), bytes.NewReader(manifestByte))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", ManifestMediaType)
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// ListFiles returns blob urls of all kernel files stored for distribution
+// version indexed by file name. The file name is kept in url fragment.
+func (r *Registry) ListFiles(distro, version string) (map[string]artifactory.CachedFile, error) {
+	repo := r.repository(distro, version)
+	manifests, err := r.manifests(repo)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]artifactory.CachedFile)
+	for _, manifest := range manifests {
+		var advisories []string
+		if a := manifest.Annotations[AnnotationAdvisories]; a != "" {
+			advisories = strings.Split(a, ",")
+		}
+		for _, layer := range manifest.Layers {
+			fileName := layer.Annotations[AnnotationTitle]
+			if fileName == "" {
+				continue
+			}
+			files[fileName] = artifactory.CachedFile{
+				URL:        r.endpoint(repo, "blobs/"+layer.Digest) + "#" + url.PathEscape(fileName),
+				Sha256:     strings.TrimPrefix(layer.Digest, "sha256:"),
+				Size:       layer.Size,
+				Advisories: advisories,
+			}
+		}
+	}
+	return files, nil
+}
+
+// GetKernels is an equivalent of artifactory.GetArtifactoryKernels, it lists
+// repositories for provided distribution versions.
+func (r *Registry) GetKernels(logger logger.Logger, distroVersions map[string][]string) (artifactory.ArtifactoryKernelCache, error) {
+	kernels := artifactory.NewKernelCache()
+	for distro, versions := range distroVersions {
+		for _, version := range versions {
+			manifests, err := r.manifests(r.repository(distro, version))
+			if err != nil {
+				return nil, err
+			}
+			for _, manifest := range manifests {
+				for _, layer := range manifest.Layers {
+					fileName := layer.Annotations[AnnotationTitle]
+					if fileName == "" {
+						logger.Debugf("skipping layer %s without title in %s/%s", layer.Digest, distro, version)
+						continue
+					}
+					kernels.Add(distro, version, fileName, layer.Annotations[AnnotationSha256])
+				}
+			}
+		}
+	}
+	return kernels, nil
+}
+
+func (r *Registry) manifests(repo string) ([]Manifest, error) {
+	tags, err := r.tags(repo)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []Manifest
+	for _, tag := range tags {
+		resp, err := r.do(repo, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodGet, r.endpoint(repo, "manifests/"+tag), nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Accept", ManifestMediaType)
+			return req, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		var manifest Manifest
+		err = decodeResponse(resp, &manifest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get manifest %s:%s: %v", repo, tag, err)
+		}
+		if manifest.Config.MediaType != ConfigMediaType {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+func (r *Registry) tags(repo string) ([]string, error) {
+	var tags []string
+	next := r.endpoint(repo, "tags/list")
+	for next != "" {
+		pageURL := next
+		resp, err := r.do(repo, func() (*http.Request, error) {
+			return http.NewRequest(http.MethodGet, pageURL, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			// repository not created yet
+			resp.Body.Close()
+			return nil, nil
+		}
+		next, err = r.nextPage(resp)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		var list tagList
+		if err := decodeResponse(resp, &list); err != nil {
+			return nil, err
+		}
+		tags = append(tags, list.Tags...)
+	}
+	return tags, nil
+}
+
+// nextPage follows RFC5988 Link header used by registries for pagination
+func (r *Registry) nextPage(resp *http.Response) (string, error) {
+	link := resp.Header.Get("Link")
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return "", nil
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("malformed Link header: %s", link)
+	}
+	u, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return "", err
+	}
+	return r.baseURL.ResolveReference(u).String(), nil
+}
+
+func (r *Registry) pushBlob(repo string, desc Descriptor, open func() (io.ReadCloser, error)) error {
+	resp, err := r.do(repo, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodHead, r.endpoint(repo, "blobs/"+desc.Digest), nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	resp, err = r.do(repo, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, r.endpoint(repo, "blobs/uploads/"), nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return statusError(resp)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+	resp, err = r.do(repo, func() (*http.Request, error) {
+		body, err := open()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPut, location.String(), body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		req.ContentLength = desc.Size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// do sends request and handles registry authentication challenge. Request is
+// recreated after authentication so bodies can be sent again.
+func (r *Registry) do(repo string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	r.authorize(req, repo)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := r.authenticate(repo, challenge); err != nil {
+		return nil, err
+	}
+	req, err = newRequest()
+	if err != nil {
+		return nil, err
+	}
+	r.authorize(req, repo)
+	return r.client.Do(req)
+}
+
+func (r *Registry) authorize(req *http.Request, repo string) {
+	r.tokens.Lock()
+	token, ok := r.tokens.tokens[repo]
+	r.tokens.Unlock()
+	switch {
+	case ok && token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case ok && r.username != "":
+		req.SetBasicAuth(r.username, r.password)
+	}
+}
+
+func (r *Registry) authenticate(repo, challenge string) error {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if r.username == "" {
+			return fmt.Errorf("registry %s requires credentials", r.baseURL.Host)
+		}
+		r.tokens.Lock()
+		r.tokens.tokens[repo] = ""
+		r.tokens.Unlock()
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported registry authentication challenge: %s", challenge)
+	}
+	params := make(map[string]string)
+	for _, match := range challengeParamRe.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid realm in authentication challenge: %s", challenge)
+	}
+	query := realm.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull,push", repo))
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := decodeResponse(resp, &tokenResponse); err != nil {
+		return fmt.Errorf("unable to get registry token: %v", err)
+	}
+	token := tokenResponse.Token
+	if token == "" {
+		token = tokenResponse.AccessToken
+	}
+	r.tokens.Lock()
+	r.tokens.tokens[repo] = token
+	r.tokens.Unlock()
+	return nil
+}
+
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(body)))
+}
+
+func fileDescriptor(file string) (Descriptor, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	return Descriptor{
+		MediaType:   FileMediaType,
+		Digest:      "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		Size:        size,
+		Annotations: map[string]string{AnnotationTitle: filepath.Base(file)},
+	}, nil
+}
+
+func bytesDescriptor(mediaType string, data []byte) Descriptor {
+	sum := sha256.Sum256(data)
+	return Descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+	}
+}
@@ -0,0 +1,168 @@
+package prune
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/artifactory"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/distribution"
+)
+
+type Action string
+
+const (
+	KEEP   Action = "keep"
+	DELETE Action = "delete"
+)
+
+// Policy defines which orphaned files are retained
+type Policy struct {
+	KeepRequired bool
+	// KeepLast keeps N most recently uploaded kernels per distribution version
+	KeepLast    int
+	GracePeriod time.Duration
+}
+
+// File is a cached file which does not match current configuration
+type File struct {
+	artifactory.ArtifactoryFile
+	Kernel string
+	Reason string
+	Action Action
+}
+
+type Plan struct {
+	Files []File
+}
+
+// NewPlan compares cached files with configuration and applies retention
+// policy on files which are not needed anymore.
+func NewPlan(distributions distribution.Distributions, files []artifactory.ArtifactoryFile, policy Policy, now time.Time) (Plan, error) {
+	var plan Plan
+	// kernel -> newest upload time per distribution version
+	kernelUploads := make(map[string]map[string]time.Time)
+	// kernel -> required by configuration of distribution version
+	required := make(map[string]bool)
+	for _, f := range files {
+		d, version := findVersion(distributions, f.Distro, f.Version)
+		file := File{ArtifactoryFile: f, Kernel: f.Name}
+		versionKey := f.Distro + "/" + f.Version
+		switch {
+		case d == nil:
+			file.Reason = "distribution not configured"
+		case version == nil:
+			file.Reason = "distribution version not configured"
+		case d.Name == string(distribution.MINIKUBE):
+			// minikube sources are matched with tags, not file names
+			continue
+		default:
+			kernel, inRange, err := d.KernelName(*version, f.Name)
+			if err != nil {
+				return plan, err
+			}
+			if kernel == "" {
+				// companion files of kernels (Debian kbuild packages, upstream
+				// kernel configs and fragments) match no parser, they are kept
+				// while their distribution version is configured
+				file.Kernel = ""
+				file.Reason = "no parser matches file"
+				file.Action = KEEP
+				plan.Files = append(plan.Files, file)
+				continue
+			}
+			file.Kernel = kernel
+			required[versionKey+"/"+kernel] = d.IsRequired(*version, kernel)
+			if !inRange {
+				file.Reason = "kernel outside version range"
+			}
+		}
+		if kernelUploads[versionKey] == nil {
+			kernelUploads[versionKey] = make(map[string]time.Time)
+		}
+		if f.Modified.After(kernelUploads[versionKey][file.Kernel]) {
+			kernelUploads[versionKey][file.Kernel] = f.Modified
+		}
+		if file.Reason != "" {
+			plan.Files = append(plan.Files, file)
+		}
+	}
+
+	for i := range plan.Files {
+		file := &plan.Files[i]
+		versionKey := file.Distro + "/" + file.Version
+		switch {
+		case file.Action == KEEP:
+			file.Reason += ", kept as companion file"
+		case policy.KeepRequired && required[versionKey+"/"+file.Kernel]:
+			file.Action = KEEP
+			file.Reason += ", required kernel"
+		case policy.KeepLast > 0 && isLatest(kernelUploads[versionKey], file.Kernel, policy.KeepLast):
+			file.Action = KEEP
+			file.Reason += fmt.Sprintf(", one of last %d kernels", policy.KeepLast)
+		case now.Sub(file.Modified) < policy.GracePeriod:
+			file.Action = KEEP
+			file.Reason += ", within grace period"
+		default:
+			file.Action = DELETE
+		}
+	}
+	sort.Slice(plan.Files, func(i, j int) bool { return plan.Files[i].Path < plan.Files[j].Path })
+	return plan, nil
+}
+
+func findVersion(distributions distribution.Distributions, distro, versionName string) (*distribution.Distribution, *distribution.Version) {
+	for i := range distributions.Distributions {
+		d := &distributions.Distributions[i]
+		if d.Name != distro {
+			continue
+		}
+		for j := range d.Versions {
+			if d.Versions[j].Name == versionName {
+				return d, &d.Versions[j]
+			}
+		}
+		return d, nil
+	}
+	return nil, nil
+}
+
+func isLatest(uploads map[string]time.Time, kernel string, keepLast int) bool {
+	var kernels []string
+	for k := range uploads {
+		kernels = append(kernels, k)
+	}
+	sort.Slice(kernels, func(i, j int) bool { return uploads[kernels[i]].After(uploads[kernels[j]]) })
+	for i := 0; i < len(kernels) && i < keepLast; i++ {
+		if kernels[i] == kernel {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Plan) ToDelete() []string {
+	var paths []string
+	for _, f := range p.Files {
+		if f.Action == DELETE {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths
+}
+
+func (p Plan) TableReport() string {
+	t := table.NewWriter()
+	t.AppendHeader(table.Row{"Path", "Kernel", "Modified", "Action", "Reason"})
+	var size int64
+	for _, f := range p.Files {
+		t.AppendRow(table.Row{f.Path, f.Kernel, f.Modified.Format(time.RFC3339), f.Action, f.Reason})
+		if f.Action == DELETE {
+			size += f.Size
+		}
+	}
+	t.SetAutoIndex(true)
+	t.AppendFooter(table.Row{"Orphaned", len(p.Files), "To delete", len(p.ToDelete()), fmt.Sprintf("%d MiB", size/1024/1024)})
+	return t.Render()
+}
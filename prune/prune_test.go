@@ -0,0 +1,139 @@
+package prune
+
+import (
+	"testing"
+	"time"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/artifactory"
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/distribution"
+)
+
+func TestNewPlan(t *testing.T) {
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-30 * 24 * time.Hour)
+	distributions := distribution.Distributions{
+		Distributions: []distribution.Distribution{
+			{
+				Name:             "ubuntu",
+				Parser:           []string{`linux-headers-(.+)-{flavor}_.+_amd64.deb`},
+				RequiredVersions: []string{"5.4.0-100-generic"},
+				Versions: []distribution.Version{
+					{Name: "20.04", MinVersion: "5.4.0-101", MaxVersion: "5.4.999-0"},
+				},
+			},
+			{
+				Name:     "minikube",
+				Versions: []distribution.Version{{Name: "v1"}},
+			},
+		},
+	}
+	file := func(distro, version, name string, modified time.Time) artifactory.ArtifactoryFile {
+		return artifactory.ArtifactoryFile{
+			Distro:   distro,
+			Version:  version,
+			Name:     name,
+			Path:     "repo/" + distro + "/" + version + "/" + name,
+			Modified: modified,
+		}
+	}
+	tests := []struct {
+		name   string
+		files  []artifactory.ArtifactoryFile
+		policy Policy
+		want   map[string]Action
+	}{
+		{
+			name: "kernel in range is not orphaned",
+			files: []artifactory.ArtifactoryFile{
+				file("ubuntu", "20.04", "linux-headers-5.4.0-110-generic_5.4.0-110.124_amd64.deb", old),
+			},
+			want: map[string]Action{},
+		},
+		{
+			name: "kernel outside range is deleted",
+			files: []artifactory.ArtifactoryFile{
+				file("ubuntu", "20.04", "linux-headers-5.4.0-99-generic_5.4.0-99.112_amd64.deb", old),
+			},
+			want: map[string]Action{"linux-headers-5.4.0-99-generic_5.4.0-99.112_amd64.deb": DELETE},
+		},
+		{
+			name: "required kernel is matched with local version",
+			files: []artifactory.ArtifactoryFile{
+				file("ubuntu", "20.04", "linux-headers-5.4.0-100-generic_5.4.0-100.113_amd64.deb", old),
+				file("ubuntu", "20.04", "linux-headers-5.4.0-10-generic_5.4.0-10.11_amd64.deb", old),
+			},
+			policy: Policy{KeepRequired: true},
+			want: map[string]Action{
+				"linux-headers-5.4.0-100-generic_5.4.0-100.113_amd64.deb": KEEP,
+				"linux-headers-5.4.0-10-generic_5.4.0-10.11_amd64.deb":    DELETE,
+			},
+		},
+		{
+			name: "required kernel is deleted without policy",
+			files: []artifactory.ArtifactoryFile{
+				file("ubuntu", "20.04", "linux-headers-5.4.0-100-generic_5.4.0-100.113_amd64.deb", old),
+			},
+			want: map[string]Action{"linux-headers-5.4.0-100-generic_5.4.0-100.113_amd64.deb": DELETE},
+		},
+		{
+			name: "companion files are kept and not ranked",
+			files: []artifactory.ArtifactoryFile{
+				file("ubuntu", "20.04", "linux-kbuild-5.4_5.4.0-99.112_amd64.deb", now),
+				file("ubuntu", "20.04", "linux_defconfig", now),
+				file("ubuntu", "20.04", "linux-headers-5.4.0-98-generic_5.4.0-98.111_amd64.deb", old.Add(time.Hour)),
+				file("ubuntu", "20.04", "linux-headers-5.4.0-97-generic_5.4.0-97.110_amd64.deb", old),
+			},
+			policy: Policy{KeepLast: 1},
+			want: map[string]Action{
+				"linux-kbuild-5.4_5.4.0-99.112_amd64.deb":               KEEP,
+				"linux_defconfig":                                       KEEP,
+				"linux-headers-5.4.0-98-generic_5.4.0-98.111_amd64.deb": KEEP,
+				"linux-headers-5.4.0-97-generic_5.4.0-97.110_amd64.deb": DELETE,
+			},
+		},
+		{
+			name: "grace period",
+			files: []artifactory.ArtifactoryFile{
+				file("ubuntu", "20.04", "linux-headers-5.4.0-99-generic_5.4.0-99.112_amd64.deb", now.Add(-time.Hour)),
+				file("ubuntu", "20.04", "linux-headers-5.4.0-98-generic_5.4.0-98.111_amd64.deb", old),
+			},
+			policy: Policy{GracePeriod: 24 * time.Hour},
+			want: map[string]Action{
+				"linux-headers-5.4.0-99-generic_5.4.0-99.112_amd64.deb": KEEP,
+				"linux-headers-5.4.0-98-generic_5.4.0-98.111_amd64.deb": DELETE,
+			},
+		},
+		{
+			name: "files of removed distribution and version are deleted",
+			files: []artifactory.ArtifactoryFile{
+				file("centos", "7", "kernel-devel-3.10.0-1127.el7.x86_64.rpm", old),
+				file("ubuntu", "18.04", "linux-headers-4.15.0-20-generic_4.15.0-20.21_amd64.deb", old),
+				file("minikube", "v1", "linux-4.19.171.tar.gz", old),
+			},
+			want: map[string]Action{
+				"kernel-devel-3.10.0-1127.el7.x86_64.rpm":                DELETE,
+				"linux-headers-4.15.0-20-generic_4.15.0-20.21_amd64.deb": DELETE,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := NewPlan(distributions, tt.files, tt.policy, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]Action)
+			for _, f := range plan.Files {
+				got[f.Name] = f.Action
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for name, action := range tt.want {
+				if got[name] != action {
+					t.Errorf("%s: got %s, want %s (%v)", name, got[name], action, plan.Files)
+				}
+			}
+		})
+	}
+}
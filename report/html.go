@@ -0,0 +1,192 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/distribution"
+)
+
+// versionSummary counts kernels of distribution version by status
+type versionSummary struct {
+	Distro        distribution.Distro
+	DistroVersion string
+	Kernels       int
+	Compiled      int
+	Failed        int
+	Shared        int
+}
+
+type htmlPhase struct {
+	Name     string
+	Duration time.Duration
+}
+
+type htmlKernel struct {
+	*distribution.Kernel
+	Status   string
+	Duration time.Duration
+	Module   string
+	Log      string
+}
+
+type htmlReport struct {
+	Start      time.Time
+	Elapsed    time.Duration
+	Incomplete []string
+	Phases     []htmlPhase
+	Summary    []versionSummary
+	Required   []htmlKernel
+	Kernels    []htmlKernel
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>vrouter kernel modules</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.compiled { color: #070; }
+.failed, .not-downloaded, .not-extracted { color: #b00; }
+.incomplete { background: #fdd; padding: 8px; }
+pre { white-space: pre-wrap; max-height: 20em; overflow: auto; margin: 0; }
+</style>
+</head>
+<body>
+<h1>vrouter kernel modules</h1>
+<p>Started {{.Start.Format "2006-01-02 15:04:05 MST"}}, took {{.Elapsed}}</p>
+{{if .Incomplete}}<p class="incomplete">INCOMPLETE: kernel discovery returned partial results for {{range $i, $s := .Incomplete}}{{if $i}}, {{end}}{{$s}}{{end}}</p>{{end}}
+
+<h2>Phases</h2>
+<table>
+<tr><th>Phase</th><th>Duration</th></tr>
+{{range .Phases}}<tr><td>{{.Name}}</td><td>{{.Duration}}</td></tr>
+{{end}}</table>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Distribution</th><th>Version</th><th>Kernels</th><th>Compiled</th><th>Failed</th><th>Shared builds</th></tr>
+{{range .Summary}}<tr><td>{{.Distro}}</td><td>{{.DistroVersion}}</td><td>{{.Kernels}}</td><td class="compiled">{{.Compiled}}</td><td{{if .Failed}} class="failed"{{end}}>{{.Failed}}</td><td>{{.Shared}}</td></tr>
+{{end}}</table>
+
+<h2>Required kernels</h2>
+{{if .Required}}<table>
+<tr><th>Distribution</th><th>Version</th><th>Kernel</th><th>Status</th></tr>
+{{range .Required}}<tr><td>{{.Distro}}</td><td>{{.DistroVersion}}</td><td>{{.Name}}{{.LocalVersion}}</td><td class="{{.Status}}">{{.Status}}</td></tr>
+{{end}}</table>{{else}}<p>No required kernels</p>{{end}}
+
+<h2>Kernels</h2>
+<p>
+<input id="filter" type="search" placeholder="filter" oninput="filterKernels()">
+<select id="status" onchange="filterKernels()">
+<option value="">all</option>
+<option value="compiled">compiled</option>
+<option value="failed">failed</option>
+<option value="not-downloaded">not-downloaded</option>
+<option value="not-extracted">not-extracted</option>
+</select>
+</p>
+<table id="kernels">
+<tr><th>Distribution</th><th>Version</th><th>Kernel</th><th>Arch</th><th>Status</th><th>Shared build</th><th>Duration</th><th>Module</th><th>Log</th><th>Error</th></tr>
+{{range .Kernels}}<tr data-status="{{.Status}}"><td>{{.Distro}}</td><td>{{.DistroVersion}}</td><td>{{.Name}}{{.LocalVersion}}</td><td>{{.Arch}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{.SharedBuild}}</td><td>{{.Duration}}</td><td>{{if .Module}}<a href="{{.Module}}">vrouter.ko</a>{{end}}</td><td>{{if .Log}}<a href="{{.Log}}">build.log</a>{{end}}</td><td>{{if .Errormsg}}<details><summary>error</summary><pre>{{.Errormsg}}</pre></details>{{end}}</td></tr>
+{{end}}</table>
+
+<script>
+function filterKernels() {
+  var text = document.getElementById("filter").value.toLowerCase();
+  var status = document.getElementById("status").value;
+  var rows = document.getElementById("kernels").rows;
+  for (var i = 1; i < rows.length; i++) {
+    var row = rows[i];
+    var match = row.textContent.toLowerCase().indexOf(text) >= 0 && (status === "" || row.dataset.status === status);
+    row.style.display = match ? "" : "none";
+  }
+}
+</script>
+</body>
+</html>
+`))
+
+// kernelStatus returns the last build phase reached by kernel
+func kernelStatus(kernel *distribution.Kernel) string {
+	switch {
+	case kernel.Compiled == distribution.SUCCESS:
+		return "compiled"
+	case kernel.Downloaded == distribution.FAIL:
+		return "not-downloaded"
+	case kernel.Extracted == distribution.FAIL:
+		return "not-extracted"
+	}
+	return "failed"
+}
+
+// HtmlReport renders self-contained html page with summary by distribution
+// version, required kernels and filterable list of kernels
+func (r Result) HtmlReport() (string, error) {
+	report := htmlReport{
+		Start:      r.Start,
+		Elapsed:    r.End.Sub(r.Start),
+		Incomplete: r.Incomplete,
+	}
+	for _, phase := range distribution.Phases {
+		if d, ok := r.Phases[phase]; ok {
+			report.Phases = append(report.Phases, htmlPhase{Name: phase, Duration: d})
+		}
+	}
+	summaries := make(map[string]*versionSummary)
+	for _, kernel := range r.Kernels {
+		k := htmlKernel{
+			Kernel:   kernel,
+			Status:   kernelStatus(kernel),
+			Duration: kernel.Duration(),
+		}
+		if kernel.Compiled {
+			k.Module = r.LinkBase + kernel.ModulePath()
+		}
+		// build log is created by Compile, which either compiled the module
+		// or recorded error
+		if kernel.Compiled || kernel.Errormsg != "" {
+			k.Log = r.LinkBase + kernel.LogPath()
+		}
+		report.Kernels = append(report.Kernels, k)
+		if kernel.Required {
+			report.Required = append(report.Required, k)
+		}
+		key := fmt.Sprintf("%s/%s", kernel.Distro, kernel.DistroVersion)
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &versionSummary{Distro: kernel.Distro, DistroVersion: kernel.DistroVersion}
+			summaries[key] = summary
+		}
+		summary.Kernels++
+		if kernel.Compiled {
+			summary.Compiled++
+		} else {
+			summary.Failed++
+		}
+		if kernel.SharedBuild != "" {
+			summary.Shared++
+		}
+	}
+	for _, summary := range summaries {
+		report.Summary = append(report.Summary, *summary)
+	}
+	sort.Slice(report.Summary, func(i, j int) bool {
+		if report.Summary[i].Distro != report.Summary[j].Distro {
+			return report.Summary[i].Distro < report.Summary[j].Distro
+		}
+		return report.Summary[i].DistroVersion < report.Summary[j].DistroVersion
+	})
+	v := strings.Builder{}
+	if err := htmlTemplate.Execute(&v, report); err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
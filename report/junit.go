@@ -0,0 +1,105 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/distribution"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func junitSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// JunitReport renders testsuite per distribution version with testcase per
+// kernel. Optional kernels which were not downloaded (e.g. missing in cache)
+// are skipped, kernels which failed to extract or compile and required kernels
+// which were not compiled are failures.
+func (r Result) JunitReport() (string, error) {
+	report := junitTestSuites{
+		Name: "vrouter kernel modules",
+		Time: junitSeconds(r.End.Sub(r.Start).Seconds()),
+	}
+	suites := make(map[string]*junitTestSuite)
+	suiteSeconds := make(map[string]float64)
+	var suiteNames []string
+	for _, kernel := range r.Kernels {
+		suiteName := fmt.Sprintf("%s/%s", kernel.Distro, kernel.DistroVersion)
+		suite, ok := suites[suiteName]
+		if !ok {
+			suite = &junitTestSuite{Name: suiteName}
+			suites[suiteName] = suite
+			suiteNames = append(suiteNames, suiteName)
+		}
+		duration := kernel.Duration().Seconds()
+		testCase := junitTestCase{
+			Name:      kernel.Name + kernel.LocalVersion,
+			ClassName: fmt.Sprintf("%s.%s", kernel.Distro, kernel.DistroVersion),
+			Time:      junitSeconds(duration),
+		}
+		// testcase names of x86_64 kernels are kept without arch suffix
+		if kernel.Arch != "" && kernel.Arch != distribution.ARCH_X86_64 {
+			testCase.Name += " (" + kernel.Arch + ")"
+		}
+		if kernel.Compiled == distribution.FAIL {
+			message := fmt.Sprintf("vrouter module not compiled: %s", kernelStatus(kernel))
+			if !kernel.Required && kernel.Downloaded == distribution.FAIL {
+				testCase.Skipped = &junitMessage{Message: "optional kernel, " + message, Body: kernel.Errormsg}
+				suite.Skipped++
+			} else {
+				testCase.Failure = &junitMessage{Message: message, Body: kernel.Errormsg}
+				suite.Failures++
+			}
+		}
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, testCase)
+		suiteSeconds[suiteName] += duration
+	}
+	sort.Strings(suiteNames)
+	for _, suiteName := range suiteNames {
+		suite := suites[suiteName]
+		suite.Time = junitSeconds(suiteSeconds[suiteName])
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+		report.Suites = append(report.Suites, *suite)
+	}
+	data, err := xml.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(data), nil
+}
@@ -0,0 +1,57 @@
+package report
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"ssd-git.juniper.net/contrail/cn2/build/kernel_downloader/distribution"
+)
+
+func TestJunitReport(t *testing.T) {
+	kernel := func(name string, required bool, downloaded, extracted, compiled distribution.Status) *distribution.Kernel {
+		return &distribution.Kernel{
+			Name:          name,
+			Distro:        distribution.UBUNTU,
+			DistroVersion: "20.04",
+			Required:      required,
+			Downloaded:    downloaded,
+			Extracted:     extracted,
+			Compiled:      compiled,
+		}
+	}
+	tests := []struct {
+		name    string
+		kernel  *distribution.Kernel
+		failure bool
+		skipped bool
+	}{
+		{name: "compiled", kernel: kernel("5.4.0-100", false, true, true, true)},
+		{name: "optional not downloaded", kernel: kernel("5.4.0-101", false, false, false, false), skipped: true},
+		{name: "required not downloaded", kernel: kernel("5.4.0-102", true, false, false, false), failure: true},
+		{name: "optional not extracted", kernel: kernel("5.4.0-103", false, true, false, false), failure: true},
+		{name: "optional compile error", kernel: kernel("5.4.0-104", false, true, true, false), failure: true},
+		{name: "required compile error", kernel: kernel("5.4.0-105", true, true, true, false), failure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Result{Kernels: []*distribution.Kernel{tt.kernel}}.JunitReport()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var report junitTestSuites
+			if err := xml.Unmarshal([]byte(out), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Tests != 1 || len(report.Suites) != 1 || len(report.Suites[0].TestCases) != 1 {
+				t.Fatalf("got report %+v", report)
+			}
+			testCase := report.Suites[0].TestCases[0]
+			if got := testCase.Failure != nil; got != tt.failure || (report.Failures == 1) != tt.failure {
+				t.Errorf("got failure %v (%d failures), want %v", testCase.Failure, report.Failures, tt.failure)
+			}
+			if got := testCase.Skipped != nil; got != tt.skipped || (report.Skipped == 1) != tt.skipped {
+				t.Errorf("got skipped %v (%d skipped), want %v", testCase.Skipped, report.Skipped, tt.skipped)
+			}
+		})
+	}
+}
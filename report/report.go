@@ -15,10 +15,68 @@ type Result struct {
 	Start   time.Time
 	End     time.Time
 	Kernels []*distribution.Kernel
+	// Incomplete lists discovery sources which returned partial results
+	Incomplete []string
+	// Phases holds total duration of build phases (discovery, download,
+	// extract, compile)
+	Phases map[string]time.Duration
+	// LinkBase is prepended to module and build log paths linked from html
+	// report, e.g. url where /kernelmodules is published
+	LinkBase string `json:"-" yaml:"-"`
+}
+
+// PlatformModule is vrouter module built for kernel shipped by platform
+// release
+type PlatformModule struct {
+	Distro        distribution.Distro
+	DistroVersion string
+	Kernel        string
+	Arch          string
+	Module        string
+	Compiled      distribution.Status
+	SharedBuild   string
+}
+
+// PlatformIndex maps platform releases to modules of kernels they ship,
+// indexed by platform and release
+func (r Result) PlatformIndex() map[string]map[string][]PlatformModule {
+	index := make(map[string]map[string][]PlatformModule)
+	for _, kernel := range r.Kernels {
+		for _, platform := range kernel.Platforms {
+			if index[platform.Platform] == nil {
+				index[platform.Platform] = make(map[string][]PlatformModule)
+			}
+			index[platform.Platform][platform.Release] = append(index[platform.Platform][platform.Release], PlatformModule{
+				Distro:        kernel.Distro,
+				DistroVersion: kernel.DistroVersion,
+				Kernel:        kernel.Name + kernel.LocalVersion,
+				Arch:          kernel.Arch,
+				Module:        kernel.ModulePath(),
+				Compiled:      kernel.Compiled,
+				SharedBuild:   kernel.SharedBuild,
+			})
+		}
+	}
+	return index
+}
+
+// Builds returns number of kernels which were built, the others share build
+// of kernel with the same header tree
+func (r Result) Builds() int {
+	builds := 0
+	for _, kernel := range r.Kernels {
+		if kernel.SharedBuild == "" {
+			builds++
+		}
+	}
+	return builds
 }
 
 func (r Result) JsonReport() (string, error) {
-	data, err := json.MarshalIndent(&r, "", "    ")
+	data, err := json.MarshalIndent(struct {
+		Result
+		Platforms map[string]map[string][]PlatformModule
+	}{r, r.PlatformIndex()}, "", "    ")
 	if err != nil {
 		return "", err
 	}
@@ -43,7 +101,7 @@ func (r Result) CsvReport() (string, error) {
 
 func (r Result) TableReport() (string, error) {
 	t := table.NewWriter()
-	t.AppendHeader(table.Row{"Distribution", "DistroVersion", "Kernel", "Success"})
+	t.AppendHeader(table.Row{"Distribution", "DistroVersion", "Kernel", "Arch", "Success", "Shared Build", "Advisories"})
 	t.SortBy([]table.SortBy{
 		{Name: "Distribution", Mode: table.Asc},
 		{Name: "DistroVersion", Mode: table.Dsc},
@@ -51,12 +109,12 @@ func (r Result) TableReport() (string, error) {
 	})
 	rowConfigAutoMerge := table.RowConfig{AutoMerge: true}
 	for _, kernel := range r.Kernels {
-		if kernel.Distro == distribution.MINIKUBE {
-			for _, mkVersion := range kernel.MinikubeVersions {
-				t.AppendRow(table.Row{kernel.Distro, mkVersion, kernel.Name, kernel.Compiled}, rowConfigAutoMerge)
+		if len(kernel.Platforms) > 0 {
+			for _, platform := range kernel.Platforms {
+				t.AppendRow(table.Row{kernel.Distro, platform.Release, kernel.Name + kernel.LocalVersion, kernel.Arch, kernel.Compiled, kernel.SharedBuild, advisories(kernel)}, rowConfigAutoMerge)
 			}
 		} else {
-			t.AppendRow(table.Row{kernel.Distro, kernel.DistroVersion, kernel.Name + kernel.LocalVersion, kernel.Compiled}, rowConfigAutoMerge)
+			t.AppendRow(table.Row{kernel.Distro, kernel.DistroVersion, kernel.Name + kernel.LocalVersion, kernel.Arch, kernel.Compiled, kernel.SharedBuild, advisories(kernel)}, rowConfigAutoMerge)
 		}
 	}
 	t.SetAutoIndex(true)
@@ -66,7 +124,18 @@ func (r Result) TableReport() (string, error) {
 		{Number: 3, AutoMerge: true},
 	})
 	elapsed := r.End.Sub(r.Start)
-	t.AppendFooter(table.Row{"Time", elapsed, "Kernel Modules", len(r.Kernels)})
+	t.AppendFooter(table.Row{"Time", elapsed, "Kernel Modules", len(r.Kernels), "Builds", r.Builds()})
+	if len(r.Incomplete) > 0 {
+		t.SetCaption("INCOMPLETE: kernel discovery returned partial results for %s", strings.Join(r.Incomplete, ", "))
+	}
 
 	return t.Render(), nil
 }
+
+func advisories(kernel *distribution.Kernel) string {
+	var ids []string
+	for _, a := range kernel.Advisories {
+		ids = append(ids, fmt.Sprintf("%s (%s)", a.ID, a.Severity))
+	}
+	return strings.Join(ids, ", ")
+}